@@ -2,17 +2,59 @@ package cmd
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/lann/devcrypt/internal"
 	"github.com/spf13/cobra"
 )
 
+var (
+	addAnonymous bool
+	addComment   string
+	addStamp     bool
+	addThreshold int
+)
+
+func init() {
+	flags := addCmd.Flags()
+	flags.BoolVar(&addAnonymous, "anonymous", false, "don't reveal the added public keys to non-recipients")
+	flags.StringVar(&addComment, "comment", "", "record a comment on the added key boxes")
+	flags.BoolVar(&addStamp, "stamp", false, "record who added the key boxes, and when")
+	flags.IntVar(&addThreshold, "threshold", 0, "require this many of the added public keys to decrypt together")
+}
+
 var addCmd = &cobra.Command{
 	Use:   "add",
 	Short: "Add a public key to an encrypted file",
-	Args:  cobra.MinimumNArgs(2),
+	Long: `Add public keys to an encrypted file.
+
+With --anonymous, the key boxes don't include the public key or label. They
+are stored in the encrypted metadata instead (as with encrypt
+--hide-metadata), so only recipients can see who the recipients are.
+
+--comment and --stamp record metadata on (non-anonymous) key boxes, which
+info shows. Expired public keys can't be added.
+
+With --threshold K, the file key is split into one share per added public key,
+any K of which recover it (K-of-n Shamir secret sharing). Share holders can't
+decrypt alone: one runs decrypt --combine with the others' shares, which they
+write with share export. A file can only have one set of threshold
+recipients; rotate re-splits the file key among them.`,
+	Args: cobra.MinimumNArgs(2),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		input := args[0]
+		if addAnonymous && (addComment != "" || addStamp) {
+			return fmt.Errorf("anonymous key boxes can't have a --comment or --stamp")
+		}
+		if addThreshold != 0 && addAnonymous {
+			return fmt.Errorf("--threshold can't be used with --anonymous")
+		}
+
+		unlock, err := lockFile(input)
+		if err != nil {
+			return err
+		}
+		defer unlock()
 
 		unsealedFile, err := unsealFile(input)
 		if err != nil {
@@ -29,12 +71,34 @@ var addCmd = &cobra.Command{
 			}
 		}
 
+		metadata, err := keyBoxMetadata(addComment, addStamp)
+		if err != nil {
+			return err
+		}
+
 		// Add pubkey(s) to file
+		if addThreshold != 0 {
+			fmt.Printf("Adding %d public keys, any %d of which can decrypt together\n", len(pubKeys), addThreshold)
+			if err := unsealedFile.AddThresholdPublicKeys(addThreshold, pubKeys...); err != nil {
+				return fmt.Errorf("adding threshold public keys: %w", err)
+			}
+		}
 		for i := range pubKeys {
 			pubKey := pubKeys[i]
-			fmt.Printf("Adding public key labeled %q\n", pubKey.Label)
-			if err := unsealedFile.AddPublicKey(pubKey); err != nil {
-				return err
+			if addThreshold == 0 {
+				fmt.Printf("Adding public key labeled %q\n", pubKey.Label)
+				add := unsealedFile.AddPublicKey
+				if addAnonymous {
+					add = unsealedFile.AddAnonymousPublicKey
+				}
+				if err := add(pubKey); err != nil {
+					return fmt.Errorf("adding %q: %w", pubKey.Label, err)
+				}
+			}
+			if metadata != (internal.KeyBoxMetadata{}) {
+				if err := unsealedFile.SetKeyBoxMetadata(pubKey, metadata); err != nil {
+					return err
+				}
 			}
 		}
 
@@ -47,3 +111,21 @@ var addCmd = &cobra.Command{
 		return nil
 	},
 }
+
+// keyBoxMetadata returns the metadata to record on added key boxes: the
+// comment and, if stamp is set, who is adding them and when.
+func keyBoxMetadata(comment string, stamp bool) (internal.KeyBoxMetadata, error) {
+	metadata := internal.KeyBoxMetadata{Comment: comment}
+	if stamp {
+		userPubKey, err := readUserPublicKey()
+		if err != nil {
+			return metadata, fmt.Errorf("reading public key: %w", err)
+		}
+		metadata.AddedBy = userPubKey.Label
+		if metadata.AddedBy == "" {
+			metadata.AddedBy = userPubKey.Fingerprint()
+		}
+		metadata.Added = time.Now().UTC().Truncate(time.Second)
+	}
+	return metadata, nil
+}
@@ -0,0 +1,280 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/lann/devcrypt/internal"
+)
+
+const (
+	agentSocketEnv = "DEVCRYPT_AUTH_SOCK"
+)
+
+var (
+	agentSocket   string
+	agentLifetime time.Duration
+)
+
+func init() {
+	startFlags := agentStartCmd.Flags()
+	startFlags.StringVarP(&agentSocket, "socket", "a", "", "unix socket path to listen on")
+	startFlags.Lookup("socket").DefValue = "<new temp dir>/agent.sock"
+	startFlags.DurationVarP(&agentLifetime, "lifetime", "t", 0, "forget the key after this long (0 = forever)")
+
+	addFlags := agentAddCmd.Flags()
+	addFlags.DurationVarP(&agentLifetime, "lifetime", "t", 0, "forget the key after this long (0 = forever)")
+
+	agentCmd.AddCommand(agentStartCmd)
+	agentCmd.AddCommand(agentAddCmd)
+	agentCmd.AddCommand(agentListCmd)
+	agentCmd.AddCommand(agentClearCmd)
+	agentCmd.AddCommand(agentLockCmd)
+	agentCmd.AddCommand(agentUnlockCmd)
+}
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Hold your private key in a background agent",
+	Long: `The devcrypt agent holds private keys in memory and unwraps file keys
+for other devcrypt commands over a unix socket. Commands use the agent
+named by $` + agentSocketEnv + ` when no --key is given.`,
+}
+
+var agentStartCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Run an agent in the foreground",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		privKey, err := readUserPrivateKey()
+		if err != nil {
+			return fmt.Errorf("reading private key: %w", err)
+		}
+
+		socketPath := agentSocket
+		if socketPath == "" {
+			dir, err := ioutil.TempDir("", "devcrypt-agent-")
+			if err != nil {
+				return fmt.Errorf("creating socket dir: %w", err)
+			}
+			defer os.Remove(dir)
+			socketPath = filepath.Join(dir, "agent.sock")
+		}
+
+		l, err := listenPrivate(socketPath)
+		if err != nil {
+			return fmt.Errorf("listening on %q: %w", socketPath, err)
+		}
+		defer os.Remove(socketPath)
+		defer l.Close()
+
+		agent := internal.NewAgent()
+		agent.AddKey(privKey, agentLifetime)
+
+		// Close the listener (removing the socket) on interrupt
+		stopped := make(chan struct{})
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sigs
+			close(stopped)
+			l.Close()
+		}()
+
+		fmt.Printf("%s=%s; export %s;\n", agentSocketEnv, socketPath, agentSocketEnv)
+		fmt.Fprintf(os.Stderr, "Agent holding key labeled %q\n", privKey.Label)
+
+		err = agent.Serve(l)
+		agent.RemoveAll()
+		select {
+		case <-stopped:
+			return nil
+		default:
+			return err
+		}
+	},
+}
+
+var agentAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Add your private key to the running agent",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialAgent()
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		privKey, err := readUserPrivateKey()
+		if err != nil {
+			return fmt.Errorf("reading private key: %w", err)
+		}
+		if err := client.AddKey(privKey, agentLifetime); err != nil {
+			return err
+		}
+		fmt.Printf("Added key labeled %q\n", privKey.Label)
+		return nil
+	},
+}
+
+var agentListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the public keys of keys held by the agent",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialAgent()
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		pubKeys, err := client.PublicKeys()
+		if err != nil {
+			return err
+		}
+		if len(pubKeys) == 0 {
+			fmt.Println("The agent has no keys (or is locked).")
+		}
+		for _, pubKey := range pubKeys {
+			fmt.Println(pubKey.MarshalString())
+		}
+		return nil
+	},
+}
+
+var agentClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Remove all keys from the agent",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialAgent()
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		if err := client.RemoveAll(); err != nil {
+			return err
+		}
+		fmt.Println("Removed all keys from the agent")
+		return nil
+	},
+}
+
+var agentLockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Lock the agent with a passphrase",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialAgent()
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		passphrase, err := readPassphrase("Lock passphrase: ")
+		if err != nil {
+			return err
+		}
+		confirm, err := readPassphrase("Again: ")
+		if err != nil {
+			return err
+		}
+		if passphrase != confirm {
+			return fmt.Errorf("passphrases don't match")
+		}
+		if err := client.Lock(passphrase); err != nil {
+			return err
+		}
+		fmt.Println("Agent locked")
+		return nil
+	},
+}
+
+var agentUnlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Unlock a locked agent",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := dialAgent()
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		passphrase, err := readPassphrase("Unlock passphrase: ")
+		if err != nil {
+			return err
+		}
+		if err := client.Unlock(passphrase); err != nil {
+			return err
+		}
+		fmt.Println("Agent unlocked")
+		return nil
+	},
+}
+
+// listenPrivate listens on a unix socket that only the current user can
+// connect to. The socket is bound in a new private directory and restricted
+// before it is linked into place, so there's no window in which others can
+// connect, whatever the umask.
+func listenPrivate(path string) (*net.UnixListener, error) {
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".devcrypt-agent-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmpPath := filepath.Join(dir, "agent.sock")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmpPath, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	// The socket's final path is removed by the caller
+	l.SetUnlinkOnClose(false)
+	if err := os.Chmod(tmpPath, 0600); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("restricting socket permissions: %w", err)
+	}
+	if err := os.Link(tmpPath, path); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func dialAgent() (*internal.AgentClient, error) {
+	socketPath := os.Getenv(agentSocketEnv)
+	if socketPath == "" {
+		return nil, fmt.Errorf("no agent found; set $%s", agentSocketEnv)
+	}
+	client, err := internal.DialAgent(socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to agent: %w", err)
+	}
+	return client, nil
+}
+
+func readPassphrase(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("can't read passphrase: stdin is not a terminal")
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("reading passphrase: %w", err)
+	}
+	return string(passphrase), nil
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var armorCmd = &cobra.Command{
+	Use:   "armor <file>",
+	Short: "Convert an encrypted file to PEM armored text",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return convertEncoding(args[0], false)
+	},
+}
+
+var dearmorCmd = &cobra.Command{
+	Use:   "dearmor <file>",
+	Short: "Convert an encrypted file to the compact binary encoding",
+	Long: `Convert an encrypted file to the compact binary encoding, which stores
+ciphertext as raw bytes rather than base64. The conversion doesn't need a
+private key and doesn't change anything that is authenticated.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return convertEncoding(args[0], true)
+	},
+}
+
+// convertEncoding rewrites an encrypted file in the binary or armored encoding.
+func convertEncoding(input string, binary bool) error {
+	unlock, err := lockFile(input)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	encFile, err := readEncFile(input)
+	if err != nil {
+		return err
+	}
+
+	if encFile.Binary == binary {
+		fmt.Printf("No change to %q\n", input)
+		return nil
+	}
+	encFile.Binary = binary
+
+	if err := rewriteFile(input, encFile); err != nil {
+		return err
+	}
+
+	fmt.Printf("Updated %q\n", input)
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	auditExpired  = "expired"
+	auditExpiring = "expiring"
+)
+
+var (
+	auditWithin time.Duration
+	auditCheck  bool
+)
+
+func init() {
+	flags := auditCmd.Flags()
+	flags.DurationVar(&auditWithin, "within", 30*24*time.Hour, "report keys that expire within this long")
+	flags.BoolVar(&auditCheck, "check", false, "exit non-zero if any recipient has expired or is expiring")
+}
+
+var auditCmd = &cobra.Command{
+	Use:   "audit [dir]",
+	Short: "List recipients whose keys have expired or will expire soon",
+	Long: `List recipients whose keys have expired or will expire soon.
+
+Each X.devcrypt file under dir (default ".") is checked for public keys that
+have expired, or will expire within --within. Anonymous recipients' keys are
+only known to recipients, so they aren't checked.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		root := "."
+		if len(args) > 0 {
+			root = args[0]
+		}
+
+		encPaths, err := findEncFiles(root)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		var found int
+		for _, encPath := range encPaths {
+			encFile, err := readEncFile(encPath)
+			if err != nil {
+				fmt.Printf("%-10s %s: %v\n", statusError, encPath, err)
+				continue
+			}
+			for _, pubKey := range encFile.PublicKeys() {
+				if pubKey.Expires.IsZero() || pubKey.Expires.After(now.Add(auditWithin)) {
+					continue
+				}
+				status := auditExpiring
+				if pubKey.Expired(now) {
+					status = auditExpired
+				}
+				fmt.Printf("%-10s %s: %q %s expires %s\n",
+					status, encPath, pubKey.Label, pubKey.Fingerprint(),
+					pubKey.Expires.Local().Format(time.RFC3339))
+				found++
+			}
+			if n := encFile.AnonymousKeyBoxes(); n > 0 {
+				fmt.Printf("%-10s %s: %d anonymous recipient(s) not checked\n", "skipped", encPath, n)
+			}
+		}
+
+		if auditCheck && found > 0 {
+			cmd.SilenceUsage = true
+			return fmt.Errorf("%d recipient(s) expired or expiring", found)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/lann/devcrypt/internal"
+)
+
+var (
+	bundleHide   bool
+	bundleDeterm bool
+	bundleOutput string
+	bundleForce  bool
+)
+
+func init() {
+	addFlags := bundleAddCmd.Flags()
+	addFlags.BoolVar(&bundleHide, "hide-metadata", false, "encrypt the entry names")
+	addFlags.BoolVar(&bundleDeterm, "deterministic", false, "reproduce the same ciphertext when re-adding unchanged entries")
+
+	extractFlags := bundleExtractCmd.Flags()
+	extractFlags.StringVarP(&bundleOutput, "output", "o", "", "output path, or - for stdout")
+	extractFlags.Lookup("output").DefValue = "<name>"
+	extractFlags.BoolVarP(&bundleForce, "force", "f", false, "overwrite an existing output file")
+
+	bundleCmd.AddCommand(bundleAddCmd)
+	bundleCmd.AddCommand(bundleRemoveCmd)
+	bundleCmd.AddCommand(bundleListCmd)
+	bundleCmd.AddCommand(bundleExtractCmd)
+}
+
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Keep many secrets in one encrypted file",
+	Long: `A bundle is an encrypted file holding many named entries under one file
+key and set of recipients. Each entry is encrypted separately, so extracting
+one doesn't decrypt the others. Use add, remove and rotate on a bundle as on
+any other encrypted file to manage its recipients.`,
+}
+
+var bundleAddCmd = &cobra.Command{
+	Use:   "add <bundle> <name> [file | -]",
+	Short: "Add or replace a bundle entry",
+	Long:  "Add or replace a bundle entry, read from file (default <name>), creating the bundle if needed.",
+	Args:  cobra.RangeArgs(2, 3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		bundlePath, name := args[0], args[1]
+		input := name
+		if len(args) > 2 {
+			input = args[2]
+		}
+		if input == stdioPath && usesStdinKey() {
+			return fmt.Errorf("can't read both the input and --key from stdin")
+		}
+
+		unlock, err := lockFile(bundlePath)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		var bundle *internal.UnsealedEncFile
+		if _, err := os.Stat(bundlePath); os.IsNotExist(err) {
+			pubKey, err := readUserPublicKey()
+			if err != nil {
+				return fmt.Errorf("reading public key: %w", err)
+			}
+			bundle, err = internal.NewUnsealedBundle()
+			if err != nil {
+				return fmt.Errorf("initing bundle: %w", err)
+			}
+			if err := bundle.AddPublicKey(pubKey); err != nil {
+				return fmt.Errorf("adding public key: %w", err)
+			}
+		} else {
+			bundle, err = unsealBundle(bundlePath)
+			if err != nil {
+				return err
+			}
+		}
+		if bundleHide {
+			bundle.HideMetadata = true
+		}
+		if cmd.Flags().Changed("deterministic") {
+			bundle.Deterministic = bundleDeterm
+		}
+
+		data, err := readInput(input)
+		if err != nil {
+			return fmt.Errorf("reading file: %w", err)
+		}
+
+		if err := bundle.WriteEntry(name, data); err != nil {
+			return fmt.Errorf("encrypting entry: %w", err)
+		}
+
+		if err := rewriteFile(bundlePath, bundle); err != nil {
+			return err
+		}
+
+		fmt.Printf("Added %q to %q\n", name, bundlePath)
+
+		return nil
+	},
+}
+
+var bundleRemoveCmd = &cobra.Command{
+	Use:     "rm <bundle> <name>...",
+	Aliases: []string{"remove"},
+	Short:   "Remove bundle entries",
+	Args:    cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		bundlePath := args[0]
+
+		unlock, err := lockFile(bundlePath)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		bundle, err := unsealBundle(bundlePath)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range args[1:] {
+			if err := bundle.RemoveEntry(name); err != nil {
+				return fmt.Errorf("removing %q: %w", name, err)
+			}
+		}
+
+		if err := rewriteFile(bundlePath, bundle); err != nil {
+			return err
+		}
+
+		fmt.Printf("Updated %q\n", bundlePath)
+
+		return nil
+	},
+}
+
+var bundleListCmd = &cobra.Command{
+	Use:     "ls <bundle>",
+	Aliases: []string{"list"},
+	Short:   "List bundle entries",
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		bundle, err := unsealBundle(args[0])
+		if err != nil {
+			return err
+		}
+
+		for _, name := range bundle.EntryNames() {
+			fmt.Println(name)
+		}
+		return nil
+	},
+}
+
+var bundleExtractCmd = &cobra.Command{
+	Use:   "extract <bundle> <name>",
+	Short: "Decrypt one bundle entry",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		bundlePath, name := args[0], args[1]
+		output := bundleOutput
+		if output == "" {
+			output = name
+		}
+
+		bundle, err := unsealBundle(bundlePath)
+		if err != nil {
+			return err
+		}
+
+		plaintext, err := bundle.ReadEntry(name)
+		if err != nil {
+			return fmt.Errorf("decrypting %q: %w", name, err)
+		}
+
+		if output != stdioPath && !bundleForce {
+			if _, err := os.Stat(output); err == nil {
+				return fmt.Errorf("%q already exists; use --force to overwrite it", output)
+			}
+		}
+
+		if err := writeOutput(output, plaintext, 0600); err != nil {
+			return fmt.Errorf("writing output: %w", err)
+		}
+
+		if output != stdioPath {
+			fmt.Printf("Extracted %q to %q\n", name, output)
+		}
+
+		return nil
+	},
+}
+
+func unsealBundle(path string) (*internal.UnsealedEncFile, error) {
+	bundle, err := unsealFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !bundle.IsBundle() {
+		return nil, fmt.Errorf("%q: %w", path, internal.ErrNotBundle)
+	}
+	return bundle, nil
+}
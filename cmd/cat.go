@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	catOffset int64
+	catLength int64
+)
+
+func init() {
+	flags := catCmd.Flags()
+	flags.Int64Var(&catOffset, "offset", 0, "start at this plaintext byte offset")
+	flags.Int64Var(&catLength, "length", -1, "write at most this many bytes (-1 = to the end)")
+}
+
+var catCmd = &cobra.Command{
+	Use:   "cat",
+	Short: "Decrypt a file to stdout",
+	Long: `Decrypt a file to stdout.
+
+With --offset or --length, only the chunks holding that range are decrypted,
+so reading part of a large file is fast. This isn't supported for compressed
+files, and the whole-file MAC isn't checked.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		input := args[0]
+
+		// Read and unseal encryped file
+		unsealedFile, err := unsealFile(input)
+		if err != nil {
+			return err
+		}
+
+		if cmd.Flags().Changed("offset") || cmd.Flags().Changed("length") {
+			if catOffset < 0 {
+				return fmt.Errorf("invalid --offset %d", catOffset)
+			}
+			r, err := unsealedFile.NewReader()
+			if err != nil {
+				return err
+			}
+			length := catLength
+			if length < 0 {
+				length = r.Size()
+			}
+			if _, err := io.Copy(os.Stdout, io.NewSectionReader(r, catOffset, length)); err != nil {
+				return fmt.Errorf("decrypting file: %w", err)
+			}
+			return nil
+		}
+
+		plaintext, err := unsealedFile.Decrypt()
+		if err != nil {
+			return fmt.Errorf("decrypting file: %w", err)
+		}
+
+		if _, err := os.Stdout.Write(plaintext); err != nil {
+			return fmt.Errorf("writing output: %w", err)
+		}
+		return nil
+	},
+}
@@ -1,15 +1,54 @@
 package cmd
 
 import (
+	"bytes"
+	"crypto/rand"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/lann/devcrypt/internal"
 )
 
+const (
+	privateKeyEnv = "DEVCRYPT_PRIVATE_KEY"
+
+	encFileSuffix = ".devcrypt"
+
+	// stdioPath stands for stdin or stdout in place of a file path.
+	stdioPath = "-"
+)
+
+// readInput reads a file, or stdin if path is "-".
+func readInput(path string) ([]byte, error) {
+	if path == stdioPath {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(path)
+}
+
+// writeOutput writes a file, or stdout if path is "-".
+func writeOutput(path string, data []byte, perm os.FileMode) error {
+	if path == stdioPath {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(path, data, perm)
+}
+
+// safeBaseName returns the base name of a (possibly untrusted) stored
+// filename, or "plaintext" if it has none.
+func safeBaseName(filename string) string {
+	base := filepath.Base(filename)
+	if base == "." || base == ".." || base == string(filepath.Separator) {
+		return "plaintext"
+	}
+	return base
+}
+
 func readEncFile(path string) (*internal.EncFile, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -25,44 +64,100 @@ func readEncFile(path string) (*internal.EncFile, error) {
 }
 
 func unsealFile(path string) (*internal.UnsealedEncFile, error) {
-	privKey, err := readUserPrivateKey()
+	identities, closeIdentities, err := readUserIdentities()
 	if err != nil {
 		return nil, fmt.Errorf("reading private key: %w", err)
 	}
+	defer closeIdentities()
 
 	encFile, err := readEncFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	unsealedFile, err := encFile.Unseal(privKey)
+	unsealedFile, err := encFile.Unseal(identities...)
 	if err != nil {
 		return nil, fmt.Errorf("unsealing file: %w", err)
 	}
 	return unsealedFile, nil
 }
 
+// lockFile takes an exclusive advisory lock for path, so that concurrent
+// devcrypt read-modify-write cycles don't lose updates. The lock is held on
+// path's directory, which (unlike path) isn't replaced by rewriteFile.
+func lockFile(path string) (unlock func(), err error) {
+	unlock, err = lockDir(filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("locking %q: %w", path, err)
+	}
+	return unlock, nil
+}
+
+// rewriteFile atomically replaces path with the output of wt, preserving the
+// mode and (where permitted) ownership of any existing file. A new file gets
+// the same mode as from os.Create.
 func rewriteFile(path string, wt io.WriterTo) error {
-	base := filepath.Base(path)
-	tmpPattern := fmt.Sprintf(".%s.*.tmp", base)
-	f, err := ioutil.TempFile(filepath.Dir(path), tmpPattern)
+	return rewriteFilePerm(path, wt, 0666)
+}
+
+// rewriteFilePerm is rewriteFile, creating a new file with perm (before the
+// umask).
+func rewriteFilePerm(path string, wt io.WriterTo, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	f, err := createTempFile(dir, filepath.Base(path), perm)
 	if err != nil {
 		return fmt.Errorf("opening tempfile: %w", err)
 	}
-	defer f.Close()
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	if info, err := os.Stat(path); err == nil {
+		preserveOwner(f, info)
+		if err := f.Chmod(info.Mode().Perm()); err != nil {
+			return fmt.Errorf("setting tempfile mode: %w", err)
+		}
+	}
 
 	if _, err := wt.WriteTo(f); err != nil {
 		return fmt.Errorf("writing tempfile: %w", err)
 	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("syncing tempfile: %w", err)
+	}
 	if err := f.Close(); err != nil {
 		return fmt.Errorf("closing tempfile: %w", err)
 	}
 	if err := os.Rename(f.Name(), path); err != nil {
 		return fmt.Errorf("error moving tempfile %q: %w", f.Name(), err)
 	}
+
+	// Make the rename durable; not all platforms can sync a directory.
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
 	return nil
 }
 
+// createTempFile creates a new temporary file for base in dir. Unlike
+// ioutil.TempFile, which always uses mode 0600, the umask applies to perm.
+func createTempFile(dir, base string, perm os.FileMode) (*os.File, error) {
+	for i := 0; ; i++ {
+		var suffix [6]byte
+		if _, err := rand.Read(suffix[:]); err != nil {
+			return nil, err
+		}
+		name := filepath.Join(dir, fmt.Sprintf(".%s.%x.tmp", base, suffix))
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if os.IsExist(err) && i < 100 {
+			continue
+		}
+		return f, err
+	}
+}
+
 func defaultConfigDir() string {
 	// e.g. ~/.config/devcrypt/
 	if userConfigDir, err := os.UserConfigDir(); err == nil {
@@ -76,8 +171,8 @@ func defaultConfigDir() string {
 }
 
 func getUserKeyPaths() (pubKeyPath, privKeyPath string, err error) {
-	if keyFlag != "" {
-		privKeyPath = keyFlag
+	if len(keyFlags) > 0 {
+		privKeyPath = keyFlags[0]
 	} else if configDir == "" {
 		err = fmt.Errorf("couldn't find a good home for your key; specify --key or --configDir")
 		return
@@ -94,7 +189,26 @@ func getUserKeyPaths() (pubKeyPath, privKeyPath string, err error) {
 	return pubKeyPath, privKeyPath, nil
 }
 
+func getKeyringDir() string {
+	if keyringFlag != "" {
+		return keyringFlag
+	}
+	if configDir == "" {
+		return ""
+	}
+	return filepath.Join(configDir, defaultKeyringDirName)
+}
+
 func readUserPublicKey() (*internal.PublicKey, error) {
+	// Derive the public key when the private key isn't read from a key file
+	if pubkeyFlag == "" && !privateKeyFromFile() {
+		privKey, err := readUserPrivateKey()
+		if err != nil {
+			return nil, err
+		}
+		return privKey.Recipient(), nil
+	}
+
 	path, _, err := getUserKeyPaths()
 	if err != nil {
 		return nil, err
@@ -115,19 +229,203 @@ func readPublicKey(path string) (*internal.PublicKey, error) {
 	return pubKey, nil
 }
 
+// usesStdinKey returns true if a --key is read from stdin.
+func usesStdinKey() bool {
+	for _, path := range keyFlags {
+		if path == stdioPath {
+			return true
+		}
+	}
+	return false
+}
+
+// privateKeyFromFile returns true if the user's private key is read from a
+// key file, which should have a matching public key file.
+func privateKeyFromFile() bool {
+	if keyFDFlag >= 0 {
+		return false
+	}
+	if len(keyFlags) > 0 {
+		return keyFlags[0] != stdioPath
+	}
+	return os.Getenv(privateKeyEnv) == ""
+}
+
+// userPrivateKey caches the private key read by readUserPrivateKey.
+var userPrivateKey *internal.PrivateKey
+
+// readUserPrivateKey reads the user's private key from the first of:
+//  1. the file descriptor given by --key-fd
+//  2. the (first) path given by --key, or stdin if it is "-"
+//  3. $DEVCRYPT_PRIVATE_KEY, holding a PEM block or a bare base64 key
+//  4. <configDir>/devcrypt_key
+//
+// Unsealing may try more keys; see readUserIdentities.
 func readUserPrivateKey() (*internal.PrivateKey, error) {
+	if userPrivateKey != nil {
+		return userPrivateKey, nil
+	}
+
+	var privKey *internal.PrivateKey
+	var err error
+	envKey := os.Getenv(privateKeyEnv)
+	if keyFDFlag >= 0 {
+		f := os.NewFile(uintptr(keyFDFlag), fmt.Sprintf("fd %d", keyFDFlag))
+		if f == nil {
+			return nil, fmt.Errorf("invalid --key-fd %d", keyFDFlag)
+		}
+		defer f.Close()
+		var data []byte
+		if data, err = ioutil.ReadAll(f); err == nil {
+			privKey, err = parsePrivateKey(data)
+		}
+	} else if len(keyFlags) == 0 && envKey != "" {
+		privKey, err = parsePrivateKey([]byte(envKey))
+	} else {
+		var path string
+		if _, path, err = getUserKeyPaths(); err == nil {
+			privKey, err = readPrivateKey(path)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	userPrivateKey = privKey
+	return privKey, nil
+}
+
+// readUserIdentities returns the identities to try when unsealing. If a key
+// was given explicitly (see readUserPrivateKey) only that key is used, or
+// every --key if it was repeated. Otherwise the keys held by a running agent,
+// the default private key and all keys in the keyring directory are used.
+// The returned func releases any agent connection.
+func readUserIdentities() ([]internal.Identity, func(), error) {
+	closer := func() {}
+	if keyFDFlag >= 0 || len(keyFlags) == 0 && os.Getenv(privateKeyEnv) != "" {
+		privKey, err := readUserPrivateKey()
+		if err != nil {
+			return nil, closer, err
+		}
+		return []internal.Identity{privKey}, closer, nil
+	}
+
+	var identities []internal.Identity
+	if len(keyFlags) > 0 {
+		for _, path := range keyFlags {
+			privKey, err := readPrivateKey(path)
+			if err != nil {
+				return nil, closer, fmt.Errorf("reading %q: %w", path, err)
+			}
+			identities = append(identities, privKey)
+		}
+		return identities, closer, nil
+	}
+
+	if os.Getenv(agentSocketEnv) != "" {
+		client, err := dialAgent()
+		if err == nil {
+			var agentIdentities []internal.Identity
+			agentIdentities, err = client.Identities()
+			identities = append(identities, agentIdentities...)
+			closer = func() { client.Close() }
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: not using agent: %v\n", err)
+		}
+	}
+
 	_, path, err := getUserKeyPaths()
+	if err != nil {
+		return nil, closer, err
+	}
+	privKey, err := readPrivateKey(path)
+	if err == nil {
+		identities = append(identities, privKey)
+	} else if !os.IsNotExist(err) {
+		return nil, closer, err
+	}
+
+	keyringIdentities, err := readKeyring(getKeyringDir())
+	if err != nil {
+		return nil, closer, err
+	}
+	identities = append(identities, keyringIdentities...)
+
+	if len(identities) == 0 {
+		return nil, closer, fmt.Errorf("no private keys found at %q or in keyring %q", path, getKeyringDir())
+	}
+	return identities, closer, nil
+}
+
+// readKeyring reads every private key in the given directory, skipping public
+// key (.pub) files. A missing directory is not an error.
+func readKeyring(dir string) ([]internal.Identity, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) && keyringFlag == "" {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading keyring: %w", err)
+	}
+
+	var identities []internal.Identity
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".pub") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		privKey, err := readPrivateKey(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping keyring file %q: %v\n", path, err)
+			continue
+		}
+		identities = append(identities, privKey)
+	}
+	return identities, nil
+}
+
+// privateKeyCache holds keys read by readPrivateKey, so that one-shot sources
+// like stdin can be used more than once per command.
+var privateKeyCache = map[string]*internal.PrivateKey{}
+
+// readPrivateKey reads a private key from a file, or stdin if path is "-".
+func readPrivateKey(path string) (*internal.PrivateKey, error) {
+	if privKey := privateKeyCache[path]; privKey != nil {
+		return privKey, nil
+	}
+
+	if path != stdioPath {
+		if err := checkKeyPerms(path); err != nil {
+			return nil, err
+		}
+	}
+	data, err := readInput(path)
 	if err != nil {
 		return nil, err
 	}
 
-	data, err := ioutil.ReadFile(path)
+	privKey, err := parsePrivateKey(data)
 	if err != nil {
 		return nil, err
 	}
+	privateKeyCache[path] = privKey
+	return privKey, nil
+}
 
+// parsePrivateKey decodes a private key PEM block or bare base64 key. Bare
+// keys get the --label.
+func parsePrivateKey(data []byte) (*internal.PrivateKey, error) {
 	privKey := &internal.PrivateKey{}
-	if err := privKey.Unmarshal(data); err != nil {
+	var err error
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		err = privKey.Unmarshal(data)
+	} else {
+		privKey.Label = label
+		err = privKey.UnmarshalBase64(string(data))
+	}
+	if err != nil {
 		return nil, err
 	}
 	return privKey, nil
@@ -1,45 +1,76 @@
 package cmd
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/lann/devcrypt/internal"
 )
 
 var (
-	decryptOutput string
+	decryptOutput   string
+	decryptForce    bool
+	decryptBackup   bool
+	decryptDiff     bool
+	decryptPreserve bool
+	decryptCombine  []string
 )
 
 func init() {
 	flags := decryptCmd.Flags()
-	flags.StringVarP(&decryptOutput, "output", "o", "", "decrypted file output path")
-	flags.Lookup("output").DefValue = "<input file without .devcrypt>"
+	flags.StringVarP(&decryptOutput, "output", "o", "", "decrypted file output path, or - for stdout")
+	flags.Lookup("output").DefValue = "<input file without .devcrypt, or stored filename>"
+
+	flags.BoolVarP(&decryptForce, "force", "f", false, "overwrite an output file with local changes")
+	flags.BoolVarP(&decryptBackup, "backup", "b", false, "back up an output file with local changes to <output>.orig")
+	flags.BoolVarP(&decryptDiff, "diff", "d", false, "show local changes to the output file instead of decrypting")
+	flags.BoolVarP(&decryptPreserve, "preserve", "p", false, "restore the file mode and modification time recorded by encrypt --preserve")
+	flags.StringArrayVar(&decryptCombine, "combine", nil, "combine a key share written by share export with your own (repeatable)")
 }
 
 var decryptCmd = &cobra.Command{
 	Use:   "decrypt",
 	Short: "Decrypt a file",
-	Args:  cobra.ExactArgs(1),
+	Long: `Decrypt a file.
+
+With --combine, a file with threshold recipients is decrypted from the given
+key shares together with your own, if any.`,
+	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		input := args[0]
 
+		// Read and unseal encryped file
+		var unsealedFile *internal.UnsealedEncFile
+		var err error
+		if len(decryptCombine) > 0 {
+			unsealedFile, err = unsealShares(input, decryptCombine)
+		} else {
+			unsealedFile, err = unsealFile(input)
+		}
+		if err != nil {
+			return err
+		}
+
 		// Derive output path (if not given)
 		output := decryptOutput
 		if output == "" {
-			suffix := ".devcrypt"
-			if strings.HasSuffix(input, suffix) {
-				output = strings.TrimSuffix(input, suffix)
-			} else {
-				return fmt.Errorf("encrypted file %q has no %s suffix; specify an --output instead", input, suffix)
+			if strings.HasSuffix(input, encFileSuffix) {
+				output = strings.TrimSuffix(input, encFileSuffix)
+			} else if unsealedFile.Filename != "" {
+				output = filepath.Join(filepath.Dir(input), safeBaseName(unsealedFile.Filename))
+			}
+			if output == "" || output == input {
+				return fmt.Errorf("can't derive an output path for %q; specify an --output instead", input)
 			}
-		}
-
-		// Read and unseal encryped file
-		unsealedFile, err := unsealFile(input)
-		if err != nil {
-			return err
 		}
 
 		// Get user key
@@ -48,13 +79,135 @@ var decryptCmd = &cobra.Command{
 			return fmt.Errorf("decrypting file: %w", err)
 		}
 
+		if output == stdioPath {
+			return writeOutput(output, plaintext, 0600)
+		}
+
+		state, err := loadDecryptState()
+		if err != nil {
+			return err
+		}
+
+		// Check for local changes to an existing output file
+		var unchanged bool
+		existing, err := ioutil.ReadFile(output)
+		if err == nil {
+			unchanged = bytes.Equal(existing, plaintext)
+			if !unchanged {
+				status, err := plaintextStatus(unsealedFile, input, output, existing, state)
+				if err != nil {
+					return fmt.Errorf("checking existing output: %w", err)
+				}
+				if status == statusPlaintextModified {
+					if done, err := resolveLocalChanges(output, plaintext); done || err != nil {
+						return err
+					}
+				}
+			}
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("reading existing output: %w", err)
+		}
+
 		// Write decrypted file
-		if err := ioutil.WriteFile(output, plaintext, 0600); err != nil {
-			return fmt.Errorf("writing output: %w", err)
+		if !unchanged {
+			if err := writeOutput(output, plaintext, 0600); err != nil {
+				return fmt.Errorf("writing output: %w", err)
+			}
+		}
+		if err := state.record(output, plaintext); err != nil {
+			return fmt.Errorf("recording decrypt state: %w", err)
+		}
+		if decryptPreserve {
+			if err := restoreMetadata(output, unsealedFile.EncFile); err != nil {
+				return err
+			}
 		}
 
-		fmt.Printf("Decrypted to %q\n", output)
+		if unchanged {
+			fmt.Printf("No change to %q\n", output)
+		} else {
+			fmt.Printf("Decrypted to %q\n", output)
+		}
 
 		return nil
 	},
 }
+
+// restoreMetadata applies the mode and modification time recorded in the
+// encrypted file, if any.
+func restoreMetadata(path string, encFile *internal.EncFile) error {
+	if encFile.Mode != 0 {
+		if err := os.Chmod(path, encFile.Mode.Perm()); err != nil {
+			return fmt.Errorf("restoring mode: %w", err)
+		}
+	}
+	if !encFile.ModTime.IsZero() {
+		if err := os.Chtimes(path, encFile.ModTime, encFile.ModTime); err != nil {
+			return fmt.Errorf("restoring modification time: %w", err)
+		}
+	}
+	return nil
+}
+
+// resolveLocalChanges decides what to do with an output file that has
+// changed since it was last decrypted, prompting if possible. It returns
+// done=true if decrypt should stop without writing.
+func resolveLocalChanges(output string, plaintext []byte) (done bool, err error) {
+	switch {
+	case decryptForce:
+		return false, nil
+	case decryptBackup:
+		return false, backupOutput(output)
+	case decryptDiff:
+		return true, diffOutput(output, plaintext)
+	case !term.IsTerminal(int(os.Stdin.Fd())):
+		return true, fmt.Errorf("%q has local changes; use --force to overwrite, --backup or --diff", output)
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Fprintf(os.Stderr, "%q has local changes. [o]verwrite, [b]ackup, [d]iff or [a]bort? ", output)
+		answer, err := in.ReadString('\n')
+		if err != nil {
+			return true, err
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "o", "overwrite":
+			return false, nil
+		case "b", "backup":
+			return false, backupOutput(output)
+		case "d", "diff":
+			if err := diffOutput(output, plaintext); err != nil {
+				return true, err
+			}
+		case "a", "abort", "":
+			return true, fmt.Errorf("not overwriting %q", output)
+		}
+	}
+}
+
+func backupOutput(output string) error {
+	backupPath := output + ".orig"
+	if err := os.Rename(output, backupPath); err != nil {
+		return fmt.Errorf("backing up %q: %w", output, err)
+	}
+	fmt.Printf("Backed up local changes to %q\n", backupPath)
+	return nil
+}
+
+// diffOutput shows the difference from the output file's local contents to
+// the decrypted plaintext.
+func diffOutput(output string, plaintext []byte) error {
+	c := exec.Command("diff", "-u",
+		"--label", output+" (local)", "--label", output+" (decrypted)",
+		output, "-")
+	c.Stdin = bytes.NewReader(plaintext)
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	err := c.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		// diff exits 1 when the files differ
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,395 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/lann/devcrypt/internal"
+)
+
+const (
+	doctorOK      = "ok"
+	doctorWarning = "warning"
+	doctorProblem = "problem"
+	doctorFixed   = "fixed"
+)
+
+var doctorFix bool
+
+func init() {
+	flags := doctorCmd.Flags()
+	flags.BoolVar(&doctorFix, "fix", false, "fix what can be fixed, e.g. private key permissions")
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor [dir]",
+	Short: "Check your keys and encrypted files for problems",
+	Long: `Check your keys and encrypted files for problems, suggesting a fix for
+each.
+
+doctor checks that:
+  - your private key can be found (e.g. that --configDir is right), parses,
+    and has a label
+  - your public key file exists and matches your private key
+  - private keys (and keyring keys) aren't readable or writable by other
+    users, and their directories aren't writable by others; devcrypt refuses
+    to use them otherwise
+  - each X.devcrypt file under dir (default ".") parses and you can unseal it
+  - each plaintext is in sync with its encrypted file (see status)
+
+With --fix, doctor corrects private key permissions and rewrites a missing or
+mismatched public key file from the private key.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		root := "."
+		if len(args) > 0 {
+			root = args[0]
+		}
+
+		r := &doctorReport{}
+		r.checkKeyPair()
+		for _, path := range doctorKeyPaths() {
+			r.checkKeyPerms(path)
+		}
+		if err := r.checkEncFiles(root); err != nil {
+			return err
+		}
+
+		if r.problems > 0 {
+			cmd.SilenceUsage = true
+			return fmt.Errorf("%d problem(s) found", r.problems)
+		}
+		return nil
+	},
+}
+
+// doctorReport prints the results of doctor's checks.
+type doctorReport struct {
+	problems int
+}
+
+func (r *doctorReport) print(status, format string, args ...interface{}) {
+	fmt.Printf("%-10s %s\n", status, fmt.Sprintf(format, args...))
+}
+
+func (r *doctorReport) ok(format string, args ...interface{}) {
+	r.print(doctorOK, format, args...)
+}
+
+// warning reports something that works but may not be what the user wants.
+func (r *doctorReport) warning(fix, format string, args ...interface{}) {
+	r.print(doctorWarning, format, args...)
+	r.suggest(fix)
+}
+
+// problem reports something that stops devcrypt working.
+func (r *doctorReport) problem(fix, format string, args ...interface{}) {
+	r.problems++
+	r.print(doctorProblem, format, args...)
+	r.suggest(fix)
+}
+
+func (r *doctorReport) suggest(fix string) {
+	if fix != "" {
+		r.print("", "fix: %s", fix)
+	}
+}
+
+// doctorKeyPaths returns the private key files devcrypt would read, other
+// than the user's key (see checkKeyPair).
+func doctorKeyPaths() []string {
+	var paths []string
+	if len(keyFlags) > 0 {
+		for _, path := range keyFlags[1:] {
+			if path != stdioPath {
+				paths = append(paths, path)
+			}
+		}
+		return paths
+	}
+	if keyFDFlag >= 0 || os.Getenv(privateKeyEnv) != "" {
+		return nil
+	}
+
+	if dir := getKeyringDir(); dir != "" {
+		entries, _ := ioutil.ReadDir(dir)
+		for _, entry := range entries {
+			if !entry.IsDir() && !strings.HasSuffix(entry.Name(), ".pub") {
+				paths = append(paths, filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+	return paths
+}
+
+// checkKeyPerms reports (and with --fix, fixes) a private key's permission
+// problems. Only devcrypt's own directories are made private to fix warnings,
+// not e.g. a project directory holding a --key.
+func (r *doctorReport) checkKeyPerms(path string) {
+	problems, err := keyPermProblems(path)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		r.problem("", "checking %q: %v", path, err)
+		return
+	}
+	if doctorFix && len(problems) > 0 {
+		for _, p := range problems {
+			ownDir := samePath(p.path, configDir) || samePath(p.path, getKeyringDir())
+			if p.fixMode == 0 || p.warnOnly && !ownDir {
+				continue
+			}
+			if ownDir {
+				p.fixMode &^= 0077
+			}
+			if err := os.Chmod(p.path, p.fixMode); err != nil {
+				r.problem("", "fixing %s: %v", p, err)
+				return
+			}
+			r.print(doctorFixed, "%s", p)
+		}
+		if problems, err = keyPermProblems(path); err != nil {
+			r.problem("", "checking %q: %v", path, err)
+			return
+		}
+	}
+
+	if len(problems) == 0 {
+		r.ok("private key %q permissions", path)
+	}
+	for _, p := range problems {
+		fix := fmt.Sprintf("chmod %04o %q", p.fixMode, p.path)
+		if p.fixMode == 0 {
+			fix = fmt.Sprintf("have the owner of %q move or remove it", p.path)
+		} else if !p.warnOnly {
+			fix += ", or run devcrypt doctor --fix"
+		}
+		if p.warnOnly {
+			r.warning(fix, "%s", p)
+		} else {
+			r.problem(fix, "%s", p)
+		}
+	}
+}
+
+// samePath returns true if path and dir are the same absolute path. An unset
+// (empty) dir matches nothing, rather than the working directory.
+func samePath(path, dir string) bool {
+	if dir == "" {
+		return false
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	absDir, err := filepath.Abs(dir)
+	return err == nil && absPath == absDir
+}
+
+// checkKeyPair checks the user's private key and, if it is read from a file,
+// the public key file beside it.
+func (r *doctorReport) checkKeyPair() {
+	if !privateKeyFromFile() {
+		privKey, err := readUserPrivateKey()
+		if err != nil {
+			r.problem("", "reading private key: %v", err)
+			return
+		}
+		r.ok("private key %s", privKey.Recipient().Fingerprint())
+		r.checkKeyDetails(privKey)
+		return
+	}
+
+	pubKeyPath, privKeyPath, err := getUserKeyPaths()
+	if err != nil {
+		r.problem("", "%v", err)
+		return
+	}
+	data, err := ioutil.ReadFile(privKeyPath)
+	if os.IsNotExist(err) {
+		r.checkMissingKey(privKeyPath)
+		return
+	} else if err != nil {
+		r.problem("", "reading private key: %v", err)
+		return
+	}
+	r.checkKeyPerms(privKeyPath)
+
+	privKey, err := parsePrivateKey(data)
+	if err != nil {
+		r.problem("restore the key from a backup, e.g. with devcrypt key recover",
+			"parsing private key %q: %v", privKeyPath, err)
+		return
+	}
+	pubKey := privKey.Recipient()
+	r.ok("private key %q (%s)", privKeyPath, pubKey.Fingerprint())
+	r.checkKeyDetails(privKey)
+	r.checkPublicKeyFile(pubKeyPath, pubKey)
+}
+
+// checkMissingKey reports a missing private key file, looking for it in
+// other config dirs.
+func (r *doctorReport) checkMissingKey(privKeyPath string) {
+	fix := "generate a key with devcrypt keygen, or recover one with devcrypt key recover"
+	if len(keyFlags) > 0 {
+		r.problem(fix, "no private key at %q", privKeyPath)
+		return
+	}
+
+	otherDirs := []string{defaultConfigDir()}
+	if homeDir, err := os.UserHomeDir(); err == nil {
+		otherDirs = append(otherDirs, filepath.Join(homeDir, ".devcrypt"))
+	}
+	for _, dir := range otherDirs {
+		if dir == "" || filepath.Clean(dir) == filepath.Clean(configDir) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, defaultKeyFileName)); err == nil {
+			fix = fmt.Sprintf("your key is in %q; pass --configDir %q", dir, dir)
+			break
+		}
+	}
+	if _, err := os.Stat(configDir); os.IsNotExist(err) {
+		r.problem(fix, "config dir %q doesn't exist", configDir)
+	} else {
+		r.problem(fix, "no private key at %q", privKeyPath)
+	}
+}
+
+// checkKeyDetails checks a private key's label and expiry.
+func (r *doctorReport) checkKeyDetails(privKey *internal.PrivateKey) {
+	if privKey.Label == "" {
+		r.warning(`add a "Label: <name>" header to the private key, then run devcrypt doctor --fix`,
+			"private key has no label, so other recipients can't tell whose it is")
+	}
+	if privKey.Recipient().Expired(time.Now()) {
+		r.warning("generate a new key with devcrypt keygen, and have it added to your files",
+			"private key expired %s", privKey.Expires.Local().Format(time.RFC3339))
+	}
+}
+
+// checkPublicKeyFile checks that a public key file matches the private key,
+// and with --fix rewrites it from the private key if not.
+func (r *doctorReport) checkPublicKeyFile(pubKeyPath string, pubKey *internal.PublicKey) {
+	filePubKey, err := readPublicKey(pubKeyPath)
+	var problem string
+	switch {
+	case os.IsNotExist(err):
+		problem = fmt.Sprintf("public key %q is missing", pubKeyPath)
+	case err != nil:
+		problem = fmt.Sprintf("reading public key %q: %v", pubKeyPath, err)
+	case filePubKey.Fingerprint() != pubKey.Fingerprint():
+		problem = fmt.Sprintf("public key %q (%s) doesn't match the private key", pubKeyPath, filePubKey.Fingerprint())
+	case filePubKey.MarshalString() != pubKey.MarshalString():
+		// Only the label, expiry or scope differ
+		r.warning("run devcrypt doctor --fix", "public key %q has different details than the private key", pubKeyPath)
+		return
+	default:
+		r.ok("public key %q matches", pubKeyPath)
+		return
+	}
+
+	if !doctorFix {
+		r.problem("run devcrypt doctor --fix to write it from the private key", "%s", problem)
+		return
+	}
+	if err == nil {
+		backupPath := pubKeyPath + ".orig"
+		if err := os.Rename(pubKeyPath, backupPath); err != nil {
+			r.problem("", "backing up %q: %v", pubKeyPath, err)
+			return
+		}
+		r.print("", "backed up the old public key to %q", backupPath)
+	}
+	if err := ioutil.WriteFile(pubKeyPath, []byte(pubKey.MarshalString()), 0644); err != nil {
+		r.problem("", "writing public key: %v", err)
+		return
+	}
+	r.print(doctorFixed, "%s", problem)
+}
+
+// checkEncFiles checks that each encrypted file under root parses, whether
+// the user can unseal it, and whether its plaintext is in sync.
+func (r *doctorReport) checkEncFiles(root string) error {
+	encPaths, err := findEncFiles(root)
+	if err != nil {
+		return err
+	}
+	if len(encPaths) == 0 {
+		r.ok("no encrypted files under %q", root)
+		return nil
+	}
+
+	identities, closeIdentities, err := readUserIdentities()
+	defer closeIdentities()
+	if err != nil {
+		r.problem("fix the private key problems above", "can't check which files you can unseal: %v", err)
+	}
+	state, err := loadDecryptState()
+	if err != nil {
+		return err
+	}
+
+	for _, encPath := range encPaths {
+		r.checkEncFile(encPath, identities, state)
+	}
+	return nil
+}
+
+func (r *doctorReport) checkEncFile(encPath string, identities []internal.Identity, state *decryptState) {
+	encFile, err := readEncFile(encPath)
+	if err != nil {
+		r.problem(fmt.Sprintf("restore it from version control, e.g. git checkout -- %q", encPath),
+			"%s: %v", encPath, err)
+		return
+	}
+	if len(identities) == 0 {
+		r.ok("%s: parses", encPath)
+		return
+	}
+
+	unsealedFile, err := encFile.Unseal(identities...)
+	var noKeyBoxErr *internal.NoKeyBoxError
+	if errors.As(err, &noKeyBoxErr) {
+		if noKeyBoxErr.Shares > 0 {
+			r.warning(fmt.Sprintf("get key shares from other holders (share export) and run devcrypt decrypt --combine <share> %q", encPath),
+				"%s: you hold %d of the %d key shares needed to unseal it", encPath, noKeyBoxErr.Shares, noKeyBoxErr.Threshold)
+		} else {
+			r.warning(fmt.Sprintf("send your public key to a recipient, who can run devcrypt add %q <your public key>", encPath),
+				"%s: you aren't a recipient", encPath)
+		}
+		return
+	} else if err != nil {
+		r.problem(fmt.Sprintf("restore it from version control, e.g. git checkout -- %q", encPath),
+			"%s: can't unseal: %v", encPath, err)
+		return
+	}
+	if encFile.IsBundle() {
+		r.ok("%s: can unseal (bundle)", encPath)
+		return
+	}
+
+	status, plainPath, err := unsealedFileStatus(encPath, unsealedFile, state)
+	switch status {
+	case statusPlaintextModified:
+		fix := fmt.Sprintf("devcrypt encrypt %q", plainPath)
+		if plainPath+encFileSuffix != encPath {
+			fix += fmt.Sprintf(" -o %q", encPath)
+		}
+		r.warning(fix, "%s: %s has changes that aren't encrypted", encPath, plainPath)
+	case statusCiphertextNewer:
+		r.warning(fmt.Sprintf("devcrypt decrypt %q", encPath),
+			"%s: %s is older than the encrypted file", encPath, plainPath)
+	case statusError:
+		r.problem("", "%s: %v", encPath, err)
+	default:
+		r.ok("%s: can unseal", encPath)
+	}
+}
@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/lann/devcrypt/internal"
+)
+
+var editCmd = &cobra.Command{
+	Use:   "edit",
+	Short: "Edit an encrypted file with $EDITOR",
+	Long: `Edit an encrypted file with $VISUAL or $EDITOR.
+
+The file is decrypted into a private temporary directory (in /dev/shm when
+available) and re-encrypted with the same file key and recipients when the
+editor exits. The plaintext is overwritten and removed afterwards.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		input := args[0]
+
+		// Remember the encrypted file to detect concurrent changes
+		origEncData, err := ioutil.ReadFile(input)
+		if err != nil {
+			return fmt.Errorf("reading encrypted file: %w", err)
+		}
+
+		unsealedFile, err := unsealFile(input)
+		if err != nil {
+			return err
+		}
+
+		plaintext, err := unsealedFile.Decrypt()
+		if err != nil {
+			return fmt.Errorf("decrypting file: %w", err)
+		}
+
+		// Write plaintext to a private temp dir
+		tmpDir, err := ioutil.TempDir(editTempBase(), "devcrypt-edit-")
+		if err != nil {
+			return fmt.Errorf("creating temp dir: %w", err)
+		}
+		defer shredDir(tmpDir)
+
+		// Keep the stored name so editors' syntax detection works
+		tmpPath := filepath.Join(tmpDir, safeBaseName(unsealedFile.Filename))
+		if err := ioutil.WriteFile(tmpPath, plaintext, 0600); err != nil {
+			return fmt.Errorf("writing temp file: %w", err)
+		}
+
+		// The editor gets terminal interrupts; clean up if we're terminated
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+		defer signal.Stop(sigs)
+		go func() {
+			for sig := range sigs {
+				if sig != os.Interrupt {
+					shredDir(tmpDir)
+					os.Exit(1)
+				}
+			}
+		}()
+
+		editorErr := runEditor(tmpPath)
+
+		edited, err := ioutil.ReadFile(tmpPath)
+		if err != nil {
+			return fmt.Errorf("reading edited file: %w", err)
+		}
+
+		// Don't re-encrypt unless plaintext has changed
+		if bytes.Equal(unsealedFile.PlaintextMAC(edited), unsealedFile.MAC) {
+			if editorErr != nil {
+				return fmt.Errorf("running editor: %w", editorErr)
+			}
+			fmt.Printf("No change to %q\n", input)
+			return nil
+		}
+
+		if err := unsealedFile.Encrypt(edited); err != nil {
+			return fmt.Errorf("encrypting file: %w", err)
+		}
+
+		// Keep (encrypted) edits aside if the editor failed or the file changed
+		if editorErr != nil {
+			return saveEditRecovery(input, unsealedFile, fmt.Errorf("running editor: %w", editorErr))
+		}
+		unlock, err := lockFile(input)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		currentEncData, err := ioutil.ReadFile(input)
+		if err != nil || !bytes.Equal(currentEncData, origEncData) {
+			return saveEditRecovery(input, unsealedFile, fmt.Errorf("%q changed while editing", input))
+		}
+
+		if err := rewriteFile(input, unsealedFile); err != nil {
+			return err
+		}
+
+		fmt.Printf("Updated %q\n", input)
+
+		// A plaintext still matching the old contents is now merely stale
+		plainPath := plaintextPath(input, unsealedFile.EncFile)
+		if existing, err := ioutil.ReadFile(plainPath); err == nil && bytes.Equal(existing, plaintext) {
+			return recordPlaintext(plainPath, plaintext)
+		}
+		return nil
+	},
+}
+
+func runEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	// Run via the shell so that e.g. EDITOR="code --wait" works
+	c := exec.Command("sh", "-c", editor+` "$@"`, editor, path)
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	return c.Run()
+}
+
+func saveEditRecovery(input string, unsealedFile *internal.UnsealedEncFile, cause error) error {
+	recoveryPath, err := writeRecoveryFile(input, unsealedFile)
+	if err != nil {
+		return fmt.Errorf("%v; saving edits also failed: %w", cause, err)
+	}
+	return fmt.Errorf("%v; saved your (encrypted) edits to %q", cause, recoveryPath)
+}
+
+// writeRecoveryFile writes to input + ".recovered", or to the first of
+// ".recovered.1", ".recovered.2", ... that doesn't exist yet, so that an
+// earlier recovery is never overwritten.
+func writeRecoveryFile(input string, wt io.WriterTo) (string, error) {
+	recoveryPath := input + ".recovered"
+	for i := 1; ; i++ {
+		f, err := os.OpenFile(recoveryPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) {
+			recoveryPath = fmt.Sprintf("%s.recovered.%d", input, i)
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		_, err = wt.WriteTo(f)
+		if err == nil {
+			err = f.Sync()
+		}
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(recoveryPath)
+			return "", err
+		}
+		return recoveryPath, nil
+	}
+}
+
+// editTempBase returns a directory for plaintext temp files, preferring a
+// tmpfs so that plaintext never reaches disk.
+func editTempBase() string {
+	if info, err := os.Stat("/dev/shm"); err == nil && info.IsDir() {
+		return "/dev/shm"
+	}
+	return ""
+}
+
+// shredDir overwrites every regular file in dir (including any editor swap
+// or backup files) before removing it.
+func shredDir(dir string) {
+	_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			if f, err := os.OpenFile(path, os.O_WRONLY, 0); err == nil {
+				_, _ = f.Write(make([]byte, info.Size()))
+				_ = f.Sync()
+				f.Close()
+			}
+		}
+		return nil
+	})
+	os.RemoveAll(dir)
+}
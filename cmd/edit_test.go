@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteRecoveryFile(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "secret.devcrypt")
+
+	for i, want := range []string{".recovered", ".recovered.1", ".recovered.2"} {
+		path, err := writeRecoveryFile(input, strings.NewReader(want))
+		assert.NoError(t, err)
+		assert.Equal(t, input+want, path, "recovery %d", i)
+	}
+
+	// Earlier recoveries are kept
+	data, err := ioutil.ReadFile(input + ".recovered")
+	assert.NoError(t, err)
+	assert.Equal(t, ".recovered", string(data))
+}
@@ -3,32 +3,79 @@ package cmd
 import (
 	"bytes"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"time"
 
 	"github.com/lann/devcrypt/internal"
 	"github.com/spf13/cobra"
 )
 
 var (
-	encryptOutput string
-	encryptForce  bool
+	encryptOutput   string
+	encryptName     string
+	encryptForce    bool
+	encryptPreserve bool
+	encryptHide     bool
+	encryptPad      string
+	encryptAnon     bool
+	encryptCompress string
+	encryptArmor    bool
+	encryptDeterm   bool
 )
 
 func init() {
 	flags := encryptCmd.Flags()
 
 	flags.StringVarP(&encryptOutput, "output", "o", "", "encrypted file output path")
-	flags.Lookup("output").DefValue = "<input file>.devcrypt"
+	flags.Lookup("output").DefValue = "<input file>.devcrypt, or <name>.devcrypt for stdin"
+
+	flags.StringVarP(&encryptName, "name", "n", "", "original filename to store (required when reading stdin)")
+	flags.Lookup("name").DefValue = "<input file>"
 
 	flags.BoolVarP(&encryptForce, "force", "f", false, "force re-encryption even if the file didn't change")
+
+	flags.BoolVarP(&encryptPreserve, "preserve", "p", false, "record the file's mode and modification time")
+
+	flags.BoolVar(&encryptHide, "hide-metadata", false, "encrypt the filename and other metadata")
+
+	flags.BoolVar(&encryptAnon, "anonymous", false, "don't reveal your public key in a new encrypted file (implies --hide-metadata)")
+
+	flags.StringVar(&encryptCompress, "compress", "", `compress plaintext before encryption: "gzip", "zstd" or "none"`)
+
+	flags.BoolVar(&encryptArmor, "armor", true, "write PEM armored text; --armor=false writes the compact binary encoding")
+
+	flags.BoolVar(&encryptDeterm, "deterministic", false, "reproduce the same ciphertext when re-encrypting unchanged content")
+
+	flags.StringVar(&encryptPad, "pad", "", `pad plaintext to hide its size: "pow2" or a bucket size in bytes`)
 }
 
 var encryptCmd = &cobra.Command{
-	Use:   "encrypt",
+	Use:   "encrypt <file | ->",
 	Short: "Encrypt a file",
+	Long:  "Encrypt a file. Use - to encrypt stdin; this requires --name.",
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
+		input := args[0]
+		name := encryptName
+		if input == stdioPath {
+			if name == "" {
+				return fmt.Errorf("encrypting stdin requires a --name")
+			}
+			if usesStdinKey() {
+				return fmt.Errorf("can't read both the input and --key from stdin")
+			}
+		} else if name == "" {
+			name = input
+		}
+		if err := internal.ValidatePadding(encryptPad); err != nil {
+			return err
+		}
+		if encryptCompress != "none" {
+			if err := internal.ValidateCompression(encryptCompress); err != nil {
+				return err
+			}
+		}
+
 		// Get user keys
 		pubKey, err := readUserPublicKey()
 		if err != nil {
@@ -36,30 +83,90 @@ var encryptCmd = &cobra.Command{
 		}
 
 		// Check for existing encrypted file
-		input := args[0]
 		output := encryptOutput
-		if output == "" {
-			output = input + ".devcrypt"
+		if output == "" && input == stdioPath {
+			output = name + encFileSuffix
+		} else if output == "" {
+			// --name only changes the stored filename
+			output = input + encFileSuffix
+		}
+
+		unlock, err := lockFile(output)
+		if err != nil {
+			return err
 		}
+		defer unlock()
 
-		unsealedFile, err := unsealFile(output)
-		if os.IsNotExist(err) {
+		var unsealedFile *internal.UnsealedEncFile
+		if _, err := os.Stat(output); os.IsNotExist(err) {
 			// Initialize new encrypted file
-			unsealedFile, err = internal.NewUnsealedEncFile(input)
+			unsealedFile, err = internal.NewUnsealedEncFile(name)
 			if err != nil {
 				return fmt.Errorf("initing unsealed file: %w", err)
 			}
 			// Add user's pubkey to the encrypted file
-			if err := unsealedFile.AddPublicKey(pubKey); err != nil {
+			add := unsealedFile.AddPublicKey
+			if encryptAnon {
+				add = unsealedFile.AddAnonymousPublicKey
+			}
+			if err := add(pubKey); err != nil {
 				return fmt.Errorf("adding public key: %w", err)
 			}
-		} else if err != nil {
-			return fmt.Errorf("unsealing existing file: %w", err)
+		} else {
+			unsealedFile, err = unsealFile(output)
+			if err != nil {
+				return fmt.Errorf("unsealing existing file: %w", err)
+			}
 		}
 		existingMAC := unsealedFile.MAC
+		existingMode := unsealedFile.Mode
+		existingModTime := unsealedFile.ModTime
+		existingHide := unsealedFile.HideMetadata
+		existingPadding := unsealedFile.Padding
+		existingCompression := unsealedFile.Compression
+		existingBinary := unsealedFile.Binary
+		existingDeterm := unsealedFile.Deterministic
+
+		// Metadata hiding, padding, compression, encoding and deterministic
+		// nonces stick once set
+		if encryptHide {
+			unsealedFile.HideMetadata = true
+		}
+		if cmd.Flags().Changed("deterministic") {
+			unsealedFile.Deterministic = encryptDeterm
+		}
+		if encryptPad != "" {
+			unsealedFile.Padding = encryptPad
+		}
+		if unsealedFile.MAC == nil || cmd.Flags().Changed("armor") {
+			unsealedFile.Binary = !encryptArmor
+		}
+		switch encryptCompress {
+		case "":
+		case "none":
+			unsealedFile.Compression = ""
+		default:
+			unsealedFile.Compression = encryptCompress
+		}
+
+		// Record file metadata, or drop any that would be stale
+		if encryptPreserve {
+			if input == stdioPath {
+				return fmt.Errorf("can't --preserve metadata of stdin")
+			}
+			info, err := os.Stat(input)
+			if err != nil {
+				return fmt.Errorf("reading file metadata: %w", err)
+			}
+			unsealedFile.Mode = info.Mode().Perm()
+			unsealedFile.ModTime = info.ModTime()
+		} else {
+			unsealedFile.Mode = 0
+			unsealedFile.ModTime = time.Time{}
+		}
 
 		// Read plaintext
-		data, err := ioutil.ReadFile(input)
+		data, err := readInput(input)
 		if err != nil {
 			return fmt.Errorf("reading file: %w", err)
 		}
@@ -71,23 +178,27 @@ var encryptCmd = &cobra.Command{
 		}
 
 		// Don't re-encrypt unless plaintext has changed
-		if !encryptForce && bytes.Equal(unsealedFile.MAC, existingMAC) {
+		unchanged := bytes.Equal(unsealedFile.MAC, existingMAC) &&
+			unsealedFile.Mode == existingMode &&
+			unsealedFile.ModTime.Equal(existingModTime) &&
+			unsealedFile.HideMetadata == existingHide &&
+			unsealedFile.Padding == existingPadding &&
+			unsealedFile.Compression == existingCompression &&
+			unsealedFile.Binary == existingBinary &&
+			unsealedFile.Deterministic == existingDeterm
+		if !encryptForce && unchanged {
 			fmt.Printf("No change to %q\n", output)
-			return nil
+		} else {
+			// Write encrypted file
+			if err := rewriteFile(output, unsealedFile); err != nil {
+				return err
+			}
+			fmt.Printf("Encrypted to %q\n", output)
 		}
 
-		// Write encrypted file
-		f, err := os.Create(output)
-		if err != nil {
-			return fmt.Errorf("creating output file: %w", err)
-		}
-		defer f.Close()
-		if _, err := unsealedFile.WriteTo(f); err != nil {
-			return fmt.Errorf("writing outout: %w", err)
+		if input != stdioPath {
+			return recordPlaintext(input, data)
 		}
-
-		fmt.Printf("Encrypted to %q\n", output)
-
 		return nil
 	},
 }
@@ -0,0 +1,23 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package cmd
+
+import (
+	"os"
+)
+
+// lockDir is a no-op where flock isn't available.
+func lockDir(dir string) (unlock func(), err error) {
+	return func() {}, nil
+}
+
+// preserveOwner is a no-op where file ownership isn't supported.
+func preserveOwner(f *os.File, info os.FileInfo) {}
+
+// keyPermProblems doesn't check anything where Unix permissions aren't
+// available.
+func keyPermProblems(path string) ([]keyPermProblem, error) {
+	_, err := os.Stat(path)
+	return nil, err
+}
@@ -0,0 +1,89 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// lockDir takes an exclusive flock on a directory, blocking until it is
+// available.
+func lockDir(dir string) (unlock func(), err error) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(d.Fd()), syscall.LOCK_EX); err != nil {
+		d.Close()
+		return nil, err
+	}
+	return func() {
+		_ = syscall.Flock(int(d.Fd()), syscall.LOCK_UN)
+		d.Close()
+	}, nil
+}
+
+// preserveOwner gives f the owner and group of an existing file. Failure
+// (e.g. when not permitted to chown) is ignored.
+func preserveOwner(f *os.File, info os.FileInfo) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		_ = f.Chown(int(stat.Uid), int(stat.Gid))
+	}
+}
+
+// keyPermProblems checks a private key file and its directory. The key must
+// be accessible only by its owner; its directory must not be writable by
+// others (unless sticky, like /tmp), and should not be readable by them.
+// Both must be owned by the user or root.
+func keyPermProblems(path string) ([]keyPermProblem, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	var problems []keyPermProblem
+	if mode := info.Mode().Perm(); mode&0077 != 0 {
+		problems = append(problems, keyPermProblem{
+			path:    path,
+			problem: fmt.Sprintf("is accessible by others (mode %04o)", mode),
+			fixMode: mode &^ 0077,
+		})
+	}
+	problems = append(problems, ownerProblems(path, info)...)
+
+	dir := filepath.Dir(path)
+	dirInfo, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	mode := dirInfo.Mode()
+	if mode.Perm()&0022 != 0 && mode&os.ModeSticky == 0 {
+		problems = append(problems, keyPermProblem{
+			path:    dir,
+			problem: fmt.Sprintf("(the key's directory) is writable by others (mode %04o)", mode.Perm()),
+			fixMode: mode.Perm() &^ 0022,
+		})
+	} else if mode.Perm()&0044 != 0 && mode&os.ModeSticky == 0 {
+		problems = append(problems, keyPermProblem{
+			path:     dir,
+			problem:  fmt.Sprintf("(the key's directory) is readable by others (mode %04o)", mode.Perm()),
+			fixMode:  mode.Perm() &^ 0077,
+			warnOnly: true,
+		})
+	}
+	return append(problems, ownerProblems(dir, dirInfo)...), nil
+}
+
+func ownerProblems(path string, info os.FileInfo) []keyPermProblem {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Uid == 0 || int(stat.Uid) == os.Getuid() {
+		return nil
+	}
+	return []keyPermProblem{{
+		path:    path,
+		problem: fmt.Sprintf("is owned by another user (uid %d)", stat.Uid),
+	}}
+}
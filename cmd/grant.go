@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/lann/devcrypt/internal"
+)
+
+var (
+	grantComment string
+	grantStamp   bool
+)
+
+func init() {
+	flags := grantCmd.Flags()
+	flags.StringVar(&grantComment, "comment", "", "record a comment on the added key boxes")
+	flags.BoolVar(&grantStamp, "stamp", false, "record who added the key boxes, and when")
+}
+
+var grantCmd = &cobra.Command{
+	Use:   "grant <pubkey> <file>...",
+	Short: "Add a public key to several encrypted files",
+	Long: `Add a public key to several encrypted files, e.g. to give a CI key made
+with keygen --ephemeral access to what a pipeline needs. Files that already
+have the key are left unchanged.`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pubKeyPath, inputs := args[0], args[1:]
+		pubKey, err := readPublicKey(pubKeyPath)
+		if err != nil {
+			return fmt.Errorf("reading public key %q: %w", pubKeyPath, err)
+		}
+		metadata, err := keyBoxMetadata(grantComment, grantStamp)
+		if err != nil {
+			return err
+		}
+
+		for _, input := range inputs {
+			if err := grantFile(input, pubKey, metadata); errors.Is(err, internal.ErrAlreadyAdded) {
+				fmt.Printf("%q already has public key labeled %q\n", input, pubKey.Label)
+			} else if err != nil {
+				return fmt.Errorf("%q: %w", input, err)
+			} else {
+				fmt.Printf("Granted public key labeled %q access to %q\n", pubKey.Label, input)
+			}
+		}
+		return nil
+	},
+}
+
+func grantFile(input string, pubKey *internal.PublicKey, metadata internal.KeyBoxMetadata) error {
+	unlock, err := lockFile(input)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	unsealedFile, err := unsealFile(input)
+	if err != nil {
+		return err
+	}
+	if err := unsealedFile.AddPublicKey(pubKey); err != nil {
+		return err
+	}
+	if metadata != (internal.KeyBoxMetadata{}) {
+		if err := unsealedFile.SetKeyBoxMetadata(pubKey, metadata); err != nil {
+			return err
+		}
+	}
+	return rewriteFile(input, unsealedFile)
+}
@@ -3,6 +3,8 @@ package cmd
 import (
 	"fmt"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -20,13 +22,77 @@ var infoCmd = &cobra.Command{
 		}
 
 		fmt.Printf("File %q:\n", filepath.Base(input))
-		fmt.Printf("  Original filename: %q\n", encFile.Filename)
-		fmt.Printf("  Plaintext size: %d\n", encFile.FileSize())
+		if encFile.IsBundle() {
+			fmt.Printf("  Bundle entries: %d\n", len(encFile.EntryNames()))
+			for _, name := range encFile.EntryNames() {
+				if name != "" {
+					fmt.Printf("    %q\n", name)
+				}
+			}
+		} else if encFile.HideMetadata {
+			fmt.Println("  Original filename: (encrypted)")
+		} else {
+			fmt.Printf("  Original filename: %q\n", encFile.Filename)
+		}
+		if encFile.IsBundle() {
+			// The bundle's own plaintext is just its index
+		} else if encFile.Compression != "" || encFile.Padding != "" {
+			stored := []string{}
+			if encFile.Compression != "" {
+				stored = append(stored, "compressed: "+encFile.Compression)
+			}
+			if encFile.Padding != "" {
+				stored = append(stored, "padded: "+encFile.Padding)
+			}
+			fmt.Printf("  Stored size: %d (%s)\n", encFile.FileSize(), strings.Join(stored, ", "))
+			if size := encFile.OriginalSize(); size >= 0 {
+				fmt.Printf("  Plaintext size: %d\n", size)
+			}
+		} else {
+			fmt.Printf("  Plaintext size: %d\n", encFile.FileSize())
+		}
+		if encFile.Mode != 0 {
+			fmt.Printf("  Mode: %04o\n", uint32(encFile.Mode))
+		}
+		if encFile.Binary {
+			fmt.Println("  Encoding: binary")
+		}
+		if encFile.Deterministic {
+			fmt.Println("  Nonces: deterministic")
+		}
+		if !encFile.ModTime.IsZero() {
+			fmt.Printf("  Modified: %s\n", encFile.ModTime.Local().Format(time.RFC3339))
+		}
 		fmt.Println()
 
 		fmt.Println("Public Keys:")
-		for _, pubKey := range encFile.PublicKeys() {
-			fmt.Println(pubKey.MarshalString())
+		for _, keyBox := range encFile.KeyBoxes() {
+			if keyBox.PublicKey == nil {
+				continue
+			}
+			fmt.Println(keyBox.PublicKey.MarshalString())
+			if keyBox.Threshold() > 0 {
+				fmt.Printf("  Share: %d (%d needed)\n", keyBox.ShareIndex(), keyBox.Threshold())
+			}
+			if !keyBox.Expires.IsZero() {
+				expired := ""
+				if keyBox.Expired(time.Now()) {
+					expired = " (expired)"
+				}
+				fmt.Printf("  Expires: %s%s\n", keyBox.Expires.Local().Format(time.RFC3339), expired)
+			}
+			if keyBox.AddedBy != "" {
+				fmt.Printf("  Added by: %q\n", keyBox.AddedBy)
+			}
+			if !keyBox.Added.IsZero() {
+				fmt.Printf("  Added: %s\n", keyBox.Added.Local().Format(time.RFC3339))
+			}
+			if keyBox.Comment != "" {
+				fmt.Printf("  Comment: %q\n", keyBox.Comment)
+			}
+		}
+		if n := encFile.AnonymousKeyBoxes(); n > 0 {
+			fmt.Printf("(and %d anonymous)\n", n)
 		}
 
 		return nil
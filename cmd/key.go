@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/lann/devcrypt/internal"
+)
+
+var (
+	keyBackupOutput       string
+	keyRecoverForce       bool
+	keyRecoverFingerprint string
+)
+
+func init() {
+	backupFlags := keyBackupCmd.Flags()
+	backupFlags.StringVarP(&keyBackupOutput, "output", "o", stdioPath, "backup output path, or - for stdout")
+
+	recoverFlags := keyRecoverCmd.Flags()
+	recoverFlags.BoolVarP(&keyRecoverForce, "force", "f", false, "overwrite existing key")
+	recoverFlags.StringVar(&keyRecoverFingerprint, "fingerprint", "", "check the recovered key against this fingerprint from a backup")
+
+	keyCmd.AddCommand(keyBackupCmd)
+	keyCmd.AddCommand(keyRecoverCmd)
+}
+
+var keyCmd = &cobra.Command{
+	Use:   "key",
+	Short: "Back up and recover private keys",
+	Long: `A private key can be written down as a 24-word recovery phrase (using the
+BIP 39 English word list), from which key recover regenerates the exact key
+pair. keygen --mnemonic prints the phrase for a new key; key backup prints a
+paper backup of an existing one.`,
+}
+
+var keyBackupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Print a paper backup of your private key",
+	Long: `Print a paper backup of your private key: its recovery phrase, its PEM
+encoding and its fingerprint, which key recover --fingerprint checks the
+recovered key against. Anyone with the backup can decrypt your files; keep it
+somewhere safe, and don't leave it in a printer queue.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		privKey, err := readUserPrivateKey()
+		if err != nil {
+			return fmt.Errorf("reading private key: %w", err)
+		}
+		backup, err := paperBackup(privKey, time.Now())
+		if err != nil {
+			return err
+		}
+		if err := writeOutput(keyBackupOutput, backup, 0600); err != nil {
+			return fmt.Errorf("writing backup: %w", err)
+		}
+		if keyBackupOutput != stdioPath {
+			fmt.Printf("Wrote backup to %q\n", keyBackupOutput)
+		}
+		return nil
+	},
+}
+
+var keyRecoverCmd = &cobra.Command{
+	Use:   "recover",
+	Short: "Regenerate a key pair from its recovery phrase",
+	Long: `Regenerate a key pair from its recovery phrase, read from the terminal (or
+stdin), and write it as keygen would. Words may be abbreviated to their first
+four letters, and word numbers from a paper backup are ignored.
+
+The phrase holds only the key itself: give the key's --label, and check
+--fingerprint against the backup to catch mistyped words.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(keyFlags) > 1 || keyFDFlag >= 0 || usesStdinKey() {
+			return fmt.Errorf("key recover needs a single --key file path")
+		}
+		pubKeyPath, privKeyPath, err := getUserKeyPaths()
+		if err != nil {
+			return err
+		}
+		if err := checkNewKeyPath(privKeyPath, keyRecoverForce); err != nil {
+			return err
+		}
+
+		phrase, err := readMnemonic()
+		if err != nil {
+			return err
+		}
+		privKey, err := internal.PrivateKeyFromMnemonic(label, phrase)
+		if err != nil {
+			return err
+		}
+		pubKey := privKey.Recipient()
+		fmt.Printf("Recovered key with fingerprint %s\n", pubKey.Fingerprint())
+		if keyRecoverFingerprint != "" && keyRecoverFingerprint != pubKey.Fingerprint() {
+			return fmt.Errorf("recovered key doesn't match fingerprint %s; check the phrase", keyRecoverFingerprint)
+		}
+
+		return writeKeyPair(pubKey, privKey, pubKeyPath, privKeyPath)
+	},
+}
+
+// readMnemonic reads a recovery phrase without echoing it, or from stdin if
+// it isn't a terminal, dropping any word numbers.
+func readMnemonic() (string, error) {
+	var data []byte
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		phrase, err := readPassphrase("Recovery phrase: ")
+		if err != nil {
+			return "", err
+		}
+		data = []byte(phrase)
+	} else {
+		var err error
+		if data, err = readInput(stdioPath); err != nil {
+			return "", fmt.Errorf("reading recovery phrase: %w", err)
+		}
+	}
+
+	var words []string
+	for _, word := range strings.Fields(string(data)) {
+		if _, err := strconv.Atoi(strings.TrimRight(word, ".):")); err != nil {
+			words = append(words, word)
+		}
+	}
+	return strings.Join(words, " "), nil
+}
+
+// formatMnemonic lays out a recovery phrase as numbered words in rows.
+func formatMnemonic(phrase string) string {
+	var sb strings.Builder
+	words := strings.Fields(phrase)
+	for row := 0; row < len(words); row += 6 {
+		var line string
+		for i := row; i < row+6 && i < len(words); i++ {
+			line += fmt.Sprintf("%4d %-10s", i+1, words[i])
+		}
+		sb.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+	return sb.String()
+}
+
+// paperBackup returns a printable backup of a private key.
+func paperBackup(privKey *internal.PrivateKey, now time.Time) ([]byte, error) {
+	privKeyEnc, err := privKey.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("private key encoding failed: %w", err)
+	}
+	pubKey := privKey.Recipient()
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "DEVCRYPT PRIVATE KEY BACKUP\n\n")
+	fmt.Fprintf(&buf, "Label:       %s\n", privKey.Label)
+	fmt.Fprintf(&buf, "Fingerprint: %s\n", pubKey.Fingerprint())
+	if !privKey.Expires.IsZero() {
+		fmt.Fprintf(&buf, "Expires:     %s\n", privKey.Expires.Format(time.RFC3339))
+	}
+	if privKey.Scope != "" {
+		fmt.Fprintf(&buf, "Scope:       %s\n", privKey.Scope)
+	}
+	fmt.Fprintf(&buf, "Written:     %s\n\n", now.Format("2006-01-02"))
+	fmt.Fprintf(&buf, "Recovery phrase:\n\n%s\n", formatMnemonic(privKey.Mnemonic()))
+	fmt.Fprintf(&buf, "To recover, run this and type in the phrase:\n")
+	fmt.Fprintf(&buf, "  devcrypt key recover --label %q --fingerprint %s\n\n", privKey.Label, pubKey.Fingerprint())
+	fmt.Fprintf(&buf, "Private key:\n\n%s", privKeyEnc)
+	return buf.Bytes(), nil
+}
@@ -6,6 +6,8 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 
@@ -13,43 +15,87 @@ import (
 )
 
 const (
-	defaultKeyFileName = "devcrypt_key"
+	defaultKeyFileName    = "devcrypt_key"
+	defaultKeyringDirName = "keyring"
 )
 
 var (
-	keygenForce bool
+	keygenForce     bool
+	keygenExpires   string
+	keygenTTL       time.Duration
+	keygenScope     string
+	keygenEphemeral bool
+	keygenMnemonic  bool
 )
 
 func init() {
 	flags := keygenCmd.Flags()
 
 	flags.BoolVarP(&keygenForce, "force", "f", false, "overwrite existing key")
+
+	flags.StringVar(&keygenExpires, "expires", "", "record an expiry date (YYYY-MM-DD or RFC 3339) in the public key")
+
+	flags.DurationVar(&keygenTTL, "ttl", 0, "expire the key after this long, e.g. 24h")
+
+	flags.StringVar(&keygenScope, "scope", "", `record what the key is for, e.g. "ci"`)
+
+	flags.BoolVar(&keygenEphemeral, "ephemeral", false, "generate a short-lived key for another --key path (--ttl defaults to 24h)")
+
+	flags.BoolVar(&keygenMnemonic, "mnemonic", false, "print a recovery phrase for the new key")
 }
 
 var keygenCmd = &cobra.Command{
 	Use:   "keygen",
 	Short: "Generate a new key",
+	Long: `Generate a new key.
+
+--ephemeral generates a short-lived key, e.g. for a CI pipeline, that expires
+after --ttl (default 24h). It must be written to an explicit --key path, so
+it can't replace your own key. Use grant to add it to encrypted files, and
+prune-expired to remove it from them once it expires.
+
+--mnemonic prints a 24-word recovery phrase that encodes the private key.
+Write it down: key recover regenerates the exact key pair from it. key backup
+prints the phrase for an existing key.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		pubKeyPath, privKeyPath, err := getUserKeyPaths()
-		if err != nil {
-			return err
+		if len(keyFlags) > 1 || keyFDFlag >= 0 || usesStdinKey() {
+			return fmt.Errorf("keygen needs a single --key file path")
 		}
-
-		// Don't overwrite private key unless explicitly requested
-		if !keygenForce {
-			if _, err := os.Stat(privKeyPath); !os.IsNotExist(err) {
-				return fmt.Errorf("key file %q already exists; --force to replace it", privKeyPath)
+		if keygenEphemeral {
+			if len(keyFlags) == 0 {
+				return fmt.Errorf("--ephemeral needs a --key path for the new key")
+			}
+			if keygenTTL == 0 && keygenExpires == "" {
+				keygenTTL = 24 * time.Hour
 			}
 		}
-
-		// Create configDir if needed.
-		keyDir := filepath.Dir(privKeyPath)
-		if _, err := os.Stat(keyDir); os.IsNotExist(err) {
-			if keyDir == defaultConfigDir() {
-				if err := os.Mkdir(keyDir, 0700); err != nil {
-					return fmt.Errorf("creating config dir %q failed: %w", keyDir, err)
-				}
+		if strings.ContainsAny(keygenScope, "\r\n") {
+			return fmt.Errorf("--scope may not contain newlines")
+		}
+		var expires time.Time
+		if keygenTTL != 0 {
+			if keygenExpires != "" {
+				return fmt.Errorf("can't use both --expires and --ttl")
+			}
+			if keygenTTL < 0 {
+				return fmt.Errorf("--ttl must be positive")
 			}
+			expires = time.Now().Add(keygenTTL).UTC().Truncate(time.Second)
+		} else if keygenExpires != "" {
+			var err error
+			if expires, err = parseDate(keygenExpires); err != nil {
+				return fmt.Errorf("invalid --expires: %w", err)
+			}
+			if !expires.After(time.Now()) {
+				return fmt.Errorf("--expires %s is in the past", keygenExpires)
+			}
+		}
+		pubKeyPath, privKeyPath, err := getUserKeyPaths()
+		if err != nil {
+			return err
+		}
+		if err := checkNewKeyPath(privKeyPath, keygenForce); err != nil {
+			return err
 		}
 
 		fmt.Printf("Generating key with label %q...\n", label)
@@ -57,28 +103,68 @@ var keygenCmd = &cobra.Command{
 		if err != nil {
 			return fmt.Errorf("key generation failed: %w", err)
 		}
+		pubKey.Expires, privKey.Expires = expires, expires
+		pubKey.Scope, privKey.Scope = keygenScope, keygenScope
 
-		// Write private key
-		privKeyEnc, err := privKey.Marshal()
-		if err != nil {
-			return fmt.Errorf("private key encoding failed: %w", err)
-		}
-		if err := ioutil.WriteFile(privKeyPath, privKeyEnc, 0600); err != nil {
-			return fmt.Errorf("private key writing failed: %w", err)
+		if err := writeKeyPair(pubKey, privKey, pubKeyPath, privKeyPath); err != nil {
+			return err
 		}
-		fmt.Printf("Wrote private key to %q\n", privKeyPath)
-
-		// Write public key
-		pubKeyEnc := pubKey.MarshalString()
-		if err := ioutil.WriteFile(pubKeyPath, []byte(pubKeyEnc), 0644); err != nil {
-			return fmt.Errorf("public key writing failed: %w", err)
+		if keygenMnemonic {
+			fmt.Printf("Recovery phrase (write it down and keep it safe; see key recover):\n%s", formatMnemonic(privKey.Mnemonic()))
 		}
-		fmt.Printf("Wrote public key to %q\n", pubKeyPath)
-		fmt.Printf("Public key:\n%s\n", pubKeyEnc)
 		return nil
 	},
 }
 
+// checkNewKeyPath refuses to overwrite a private key unless forced.
+func checkNewKeyPath(privKeyPath string, force bool) error {
+	if !force {
+		if _, err := os.Stat(privKeyPath); !os.IsNotExist(err) {
+			return fmt.Errorf("key file %q already exists; --force to replace it", privKeyPath)
+		}
+	}
+	return nil
+}
+
+// writeKeyPair writes a new key pair, creating the key's directory (with
+// mode 0700) if needed.
+func writeKeyPair(pubKey *internal.PublicKey, privKey *internal.PrivateKey, pubKeyPath, privKeyPath string) error {
+	keyDir := filepath.Dir(privKeyPath)
+	if err := os.MkdirAll(keyDir, 0700); err != nil {
+		return fmt.Errorf("creating key dir %q failed: %w", keyDir, err)
+	}
+
+	// Write private key
+	privKeyEnc, err := privKey.Marshal()
+	if err != nil {
+		return fmt.Errorf("private key encoding failed: %w", err)
+	}
+	// WriteFile keeps the mode of a replaced key
+	if err := os.Chmod(privKeyPath, 0600); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("private key writing failed: %w", err)
+	}
+	if err := ioutil.WriteFile(privKeyPath, privKeyEnc, 0600); err != nil {
+		return fmt.Errorf("private key writing failed: %w", err)
+	}
+	fmt.Printf("Wrote private key to %q\n", privKeyPath)
+	problems, err := keyPermProblems(privKeyPath)
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, "Warning: %s; run devcrypt doctor --fix\n", p)
+	}
+
+	// Write public key
+	pubKeyEnc := pubKey.MarshalString()
+	if err := ioutil.WriteFile(pubKeyPath, []byte(pubKeyEnc), 0644); err != nil {
+		return fmt.Errorf("public key writing failed: %w", err)
+	}
+	fmt.Printf("Wrote public key to %q\n", pubKeyPath)
+	fmt.Printf("Public key:\n%s\n", pubKeyEnc)
+	return nil
+}
+
 func defaultLabel() string {
 	label := ""
 	if u, err := user.Current(); err == nil {
@@ -89,3 +175,11 @@ func defaultLabel() string {
 	}
 	return label
 }
+
+// parseDate parses a date (as midnight UTC) or an RFC 3339 time.
+func parseDate(value string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// keyPermProblem is something that lets other users read or replace a private
+// key: loose permissions on the key file or its directory, or either being
+// owned by someone else. Like ssh, devcrypt refuses such keys.
+type keyPermProblem struct {
+	path    string
+	problem string
+
+	// fixMode is the mode that fixes the problem, or 0 if chmod can't
+	fixMode os.FileMode
+
+	// warnOnly problems don't stop the key being used
+	warnOnly bool
+}
+
+func (p keyPermProblem) String() string {
+	return fmt.Sprintf("%q %s", p.path, p.problem)
+}
+
+// checkKeyPerms refuses a private key file that others could read or replace,
+// unless --insecure-key-perms is given. Lesser problems only print warnings.
+func checkKeyPerms(path string) error {
+	problems, err := keyPermProblems(path)
+	if err != nil {
+		return err
+	}
+	var refused []string
+	for _, p := range problems {
+		if p.warnOnly || insecureKeyPerms {
+			fmt.Fprintf(os.Stderr, "Warning: %s; see devcrypt doctor\n", p)
+		} else {
+			refused = append(refused, p.String())
+		}
+	}
+	if len(refused) > 0 {
+		return fmt.Errorf("refusing to use private key: %s\n"+
+			"Run devcrypt doctor --fix, or pass --insecure-key-perms to use it anyway",
+			strings.Join(refused, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/lann/devcrypt/internal"
+)
+
+var (
+	pruneScope  string
+	pruneDryRun bool
+)
+
+func init() {
+	flags := pruneExpiredCmd.Flags()
+	flags.StringVar(&pruneScope, "scope", "", "only prune keys with this scope")
+	flags.BoolVarP(&pruneDryRun, "dry-run", "n", false, "show what would be pruned without changing anything")
+}
+
+var pruneExpiredCmd = &cobra.Command{
+	Use:   "prune-expired [dir]",
+	Short: "Remove expired public keys from encrypted files and rotate their file keys",
+	Long: `Remove expired public keys from encrypted files and rotate their file keys.
+
+Each X.devcrypt file under dir (default ".") that has a public key past its
+expiry loses that key box, and then gets a new file key (as with rotate) so
+that the expired key can't decrypt later changes. You must be a recipient of
+each file changed. Files with anonymous recipients are unsealed to check
+them.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		root := "."
+		if len(args) > 0 {
+			root = args[0]
+		}
+
+		identities, closeIdentities, err := readUserIdentities()
+		if err != nil {
+			return fmt.Errorf("reading private key: %w", err)
+		}
+		defer closeIdentities()
+
+		encPaths, err := findEncFiles(root)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		status := "pruned"
+		if pruneDryRun {
+			status = "expired"
+		}
+		var failed int
+		for _, encPath := range encPaths {
+			pruned, err := pruneFile(encPath, identities, now)
+			if err != nil {
+				fmt.Printf("%-10s %s: %v\n", statusError, encPath, err)
+				failed++
+				continue
+			}
+			for _, pubKey := range pruned {
+				fmt.Printf("%-10s %s: %q %s expired %s\n",
+					status, encPath, pubKey.Label, pubKey.Fingerprint(),
+					pubKey.Expires.Local().Format(time.RFC3339))
+			}
+		}
+
+		if failed > 0 {
+			cmd.SilenceUsage = true
+			return fmt.Errorf("%d file(s) couldn't be pruned", failed)
+		}
+		return nil
+	},
+}
+
+// pruneFile removes expired public keys from an encrypted file and rotates
+// its file key, returning the removed keys.
+func pruneFile(encPath string, identities []internal.Identity, now time.Time) ([]*internal.PublicKey, error) {
+	unlock, err := lockFile(encPath)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	encFile, err := readEncFile(encPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(prunable(encFile.PublicKeys(), now)) == 0 && encFile.AnonymousKeyBoxes() == 0 {
+		return nil, nil
+	}
+
+	unsealedFile, err := encFile.Unseal(identities...)
+	if err != nil {
+		return nil, fmt.Errorf("unsealing file: %w", err)
+	}
+	pubKeys := unsealedFile.PublicKeys()
+	expired := prunable(pubKeys, now)
+	if len(expired) == 0 || pruneDryRun {
+		return expired, nil
+	}
+	if len(expired) == len(pubKeys) {
+		return nil, fmt.Errorf("refusing to remove all public keys")
+	}
+
+	for _, pubKey := range expired {
+		if err := unsealedFile.RemovePublicKey(pubKey); err != nil {
+			return nil, fmt.Errorf("removing %q: %w", pubKey.Label, err)
+		}
+	}
+	if err := unsealedFile.RotateFileKey(); err != nil {
+		return nil, fmt.Errorf("rotating file key: %w", err)
+	}
+	if err := rewriteFile(encPath, unsealedFile); err != nil {
+		return nil, err
+	}
+	return expired, nil
+}
+
+// prunable returns the public keys that have expired and match any --scope.
+func prunable(pubKeys []*internal.PublicKey, now time.Time) []*internal.PublicKey {
+	var expired []*internal.PublicKey
+	for _, pubKey := range pubKeys {
+		if pubKey.Expired(now) && (pruneScope == "" || pubKey.Scope == pruneScope) {
+			expired = append(expired, pubKey)
+		}
+	}
+	return expired
+}
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 
 	"github.com/spf13/cobra"
 )
@@ -13,11 +14,29 @@ var removeCmd = &cobra.Command{
 	RunE: func(cmd *cobra.Command, args []string) error {
 		input := args[0]
 
+		unlock, err := lockFile(input)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
 		encFile, err := readEncFile(input)
 		if err != nil {
 			return err
 		}
 
+		// Anonymous key boxes' public keys are in the encrypted metadata,
+		// which must be resealed without them. Key box options are
+		// authenticated by the HeaderMAC, which must be recomputed.
+		var output io.WriterTo = encFile
+		if encFile.AnonymousKeyBoxes() > 0 || encFile.HasKeyBoxOptions() {
+			unsealedFile, err := unsealFile(input)
+			if err != nil {
+				return err
+			}
+			encFile, output = unsealedFile.EncFile, unsealedFile
+		}
+
 		pubKeys := encFile.PublicKeys()
 		removals := args[1:]
 		for _, removal := range removals {
@@ -35,7 +54,7 @@ var removeCmd = &cobra.Command{
 				if remove {
 					fmt.Println(pubKey.MarshalString())
 					if err := encFile.RemovePublicKey(pubKey); err != nil {
-						return err
+						return fmt.Errorf("removing %q: %w", pubKey.Label, err)
 					}
 					fmt.Println()
 					removed = true
@@ -50,7 +69,7 @@ var removeCmd = &cobra.Command{
 			return fmt.Errorf("refusing to remove all public keys")
 		}
 
-		if err := rewriteFile(input, encFile); err != nil {
+		if err := rewriteFile(input, output); err != nil {
 			return err
 		}
 
@@ -8,10 +8,14 @@ import (
 )
 
 var (
-	configDir  string
-	label      string
-	keyFlag    string
-	pubkeyFlag string
+	configDir   string
+	label       string
+	keyFlags    []string
+	keyFDFlag   int
+	keyringFlag string
+	pubkeyFlag  string
+
+	insecureKeyPerms bool
 )
 
 var rootCmd = &cobra.Command{
@@ -25,19 +29,39 @@ func init() {
 
 	flags.StringVarP(&label, "label", "l", defaultLabel(), "label for key")
 
-	flags.StringVarP(&keyFlag, "key", "k", "", "path to private key")
-	flags.Lookup("key").DefValue = "<configDir>/devcrypt_key"
+	flags.StringArrayVarP(&keyFlags, "key", "k", nil, "path to private key, or - for stdin; repeat to try several keys")
+	flags.Lookup("key").DefValue = "$" + privateKeyEnv + " or <configDir>/devcrypt_key"
+
+	flags.IntVar(&keyFDFlag, "key-fd", -1, "read private key from this file descriptor")
+
+	flags.StringVar(&keyringFlag, "keyring", "", "directory of extra private keys to try when unsealing")
+	flags.Lookup("keyring").DefValue = "<configDir>/keyring"
 
 	flags.StringVarP(&pubkeyFlag, "pubkey", "K", "", "path to public key")
 	flags.Lookup("pubkey").DefValue = "<key>.pub"
 
+	flags.BoolVar(&insecureKeyPerms, "insecure-key-perms", false, "use private keys that others can read or replace, with a warning")
+
 	rootCmd.AddCommand(addCmd)
+	rootCmd.AddCommand(agentCmd)
+	rootCmd.AddCommand(armorCmd)
+	rootCmd.AddCommand(auditCmd)
+	rootCmd.AddCommand(bundleCmd)
+	rootCmd.AddCommand(catCmd)
+	rootCmd.AddCommand(dearmorCmd)
 	rootCmd.AddCommand(decryptCmd)
+	rootCmd.AddCommand(doctorCmd)
+	rootCmd.AddCommand(editCmd)
 	rootCmd.AddCommand(encryptCmd)
+	rootCmd.AddCommand(grantCmd)
 	rootCmd.AddCommand(infoCmd)
+	rootCmd.AddCommand(keyCmd)
 	rootCmd.AddCommand(keygenCmd)
+	rootCmd.AddCommand(pruneExpiredCmd)
 	rootCmd.AddCommand(removeCmd)
 	rootCmd.AddCommand(rotateCmd)
+	rootCmd.AddCommand(shareCmd)
+	rootCmd.AddCommand(statusCmd)
 }
 
 // Execute executes.
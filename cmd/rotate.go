@@ -13,6 +13,12 @@ var rotateCmd = &cobra.Command{
 	RunE: func(cmd *cobra.Command, args []string) error {
 		input := args[0]
 
+		unlock, err := lockFile(input)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
 		// Read and unseal encryped file
 		unsealedFile, err := unsealFile(input)
 		if err != nil {
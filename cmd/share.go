@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/spf13/cobra"
+
+	"github.com/lann/devcrypt/internal"
+)
+
+var shareOutput string
+
+func init() {
+	exportFlags := shareExportCmd.Flags()
+	exportFlags.StringVarP(&shareOutput, "output", "o", stdioPath, "key share output path, or - for stdout")
+
+	shareCmd.AddCommand(shareExportCmd)
+}
+
+var shareCmd = &cobra.Command{
+	Use:   "share",
+	Short: "Manage key shares of files with threshold recipients",
+	Long: `Files with threshold recipients (see add --threshold) can only be decrypted
+by combining enough key shares. Each share holder exports their share, and
+passes it to one holder, who runs decrypt --combine.`,
+}
+
+var shareExportCmd = &cobra.Command{
+	Use:   "export <file>",
+	Short: "Write your key share of an encrypted file",
+	Long: `Write your key share of an encrypted file, for another share holder to
+decrypt it with decrypt --combine. A key share is as sensitive as the file
+itself once enough of them are together: send it privately, and delete it
+after use.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		input := args[0]
+
+		identities, closeIdentities, err := readUserIdentities()
+		if err != nil {
+			return fmt.Errorf("reading private key: %w", err)
+		}
+		defer closeIdentities()
+
+		encFile, err := readEncFile(input)
+		if err != nil {
+			return err
+		}
+		shares, err := encFile.OpenShares(identities...)
+		if errors.Is(err, internal.ErrKeyBoxNotFound) {
+			return fmt.Errorf("no key share of %q for your key", input)
+		} else if err != nil {
+			return fmt.Errorf("opening key share: %w", err)
+		}
+
+		var data []byte
+		for _, share := range shares {
+			shareData, err := share.Marshal()
+			if err != nil {
+				return err
+			}
+			data = append(data, shareData...)
+		}
+		if err := writeOutput(shareOutput, data, 0600); err != nil {
+			return fmt.Errorf("writing key share: %w", err)
+		}
+		if shareOutput != stdioPath {
+			fmt.Printf("Wrote key share of %q to %q\n", input, shareOutput)
+		}
+		return nil
+	},
+}
+
+// readKeyShare reads a key share written by share export.
+func readKeyShare(path string) (*internal.KeyShare, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	share := &internal.KeyShare{}
+	if err := share.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	return share, nil
+}
+
+// unsealShares reads and unseals an encrypted file from the key shares at
+// sharePaths together with any of the user's own.
+func unsealShares(path string, sharePaths []string) (*internal.UnsealedEncFile, error) {
+	identities, closeIdentities, err := readUserIdentities()
+	if err != nil {
+		return nil, fmt.Errorf("reading private key: %w", err)
+	}
+	defer closeIdentities()
+
+	encFile, err := readEncFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	shares, err := encFile.OpenShares(identities...)
+	if err != nil && !errors.Is(err, internal.ErrKeyBoxNotFound) {
+		return nil, fmt.Errorf("opening key share: %w", err)
+	}
+	for _, sharePath := range sharePaths {
+		share, err := readKeyShare(sharePath)
+		if err != nil {
+			return nil, fmt.Errorf("reading key share %q: %w", sharePath, err)
+		}
+		shares = append(shares, share)
+	}
+
+	unsealedFile, err := encFile.UnsealShares(shares...)
+	if err != nil {
+		return nil, fmt.Errorf("unsealing file: %w", err)
+	}
+	return unsealedFile, nil
+}
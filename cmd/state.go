@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	decryptStateFileName = "decrypted.json"
+)
+
+// decryptState records a digest of the plaintext last written by decrypt, or
+// last encrypted, for each plaintext path. A plaintext file whose digest
+// still matches hasn't been modified locally since. Unlike the file's MAC,
+// the digest doesn't depend on the file key, so it still matches after a
+// rotate.
+type decryptState struct {
+	path    string
+	Digests map[string]string `json:"sha256"`
+}
+
+func loadDecryptState() (*decryptState, error) {
+	if configDir == "" {
+		return &decryptState{Digests: map[string]string{}}, nil
+	}
+	return readDecryptState(filepath.Join(configDir, decryptStateFileName))
+}
+
+func readDecryptState(path string) (*decryptState, error) {
+	state := &decryptState{path: path, Digests: map[string]string{}}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading decrypt state: %w", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("decoding decrypt state %q: %w", path, err)
+	}
+	if state.Digests == nil {
+		state.Digests = map[string]string{}
+	}
+	return state, nil
+}
+
+// lastDigest returns the digest recorded for the plaintext path, if any.
+func (s *decryptState) lastDigest(path string) []byte {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil
+	}
+	digest, err := hex.DecodeString(s.Digests[absPath])
+	if err != nil || len(digest) == 0 {
+		return nil
+	}
+	return digest
+}
+
+// plaintextDigest returns the digest of a plaintext to record.
+func plaintextDigest(plaintext []byte) []byte {
+	digest := sha256.Sum256(plaintext)
+	return digest[:]
+}
+
+// record saves the digest of the plaintext written to path. The state file
+// is locked and reread, so concurrent records aren't lost, and replaced
+// atomically.
+func (s *decryptState) record(path string, plaintext []byte) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	digest := hex.EncodeToString(plaintextDigest(plaintext))
+	s.Digests[absPath] = digest
+	if s.path == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	unlock, err := lockFile(s.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, err := readDecryptState(s.path)
+	if err != nil {
+		return err
+	}
+	current.Digests[absPath] = digest
+	data, err := json.MarshalIndent(current, "", "  ")
+	if err != nil {
+		return err
+	}
+	return rewriteFilePerm(s.path, bytes.NewReader(data), 0600)
+}
+
+// recordPlaintext records that path holds a plaintext in sync with its
+// encrypted file, so that a later decrypt of a newer encrypted file can
+// overwrite it.
+func recordPlaintext(path string, plaintext []byte) error {
+	state, err := loadDecryptState()
+	if err != nil {
+		return err
+	}
+	if err := state.record(path, plaintext); err != nil {
+		return fmt.Errorf("recording decrypt state: %w", err)
+	}
+	return nil
+}
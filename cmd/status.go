@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/lann/devcrypt/internal"
+)
+
+const (
+	statusInSync            = "in-sync"
+	statusPlaintextModified = "plaintext-modified"
+	statusCiphertextNewer   = "ciphertext-newer"
+	statusPlaintextMissing  = "plaintext-missing"
+	statusNotRecipient      = "not-a-recipient"
+	statusError             = "error"
+
+	// Bundles have no single plaintext, so they are skipped
+	statusBundle = "bundle"
+)
+
+var (
+	statusCheck bool
+)
+
+func init() {
+	flags := statusCmd.Flags()
+	flags.BoolVar(&statusCheck, "check", false, "exit non-zero if any plaintext has changes that aren't encrypted")
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status [dir]",
+	Short: "Show which encrypted files are out of sync with their plaintext",
+	Long: `Show which encrypted files are out of sync with their plaintext.
+
+Each X.devcrypt file under dir (default ".") is paired with its plaintext X
+and reported as one of:
+  in-sync             the plaintext matches the encrypted file
+  plaintext-modified  the plaintext has changes that aren't encrypted
+  ciphertext-newer    the encrypted file changed since it was decrypted or
+                      encrypted here
+  plaintext-missing   there is no plaintext file
+  not-a-recipient     none of your keys can unseal the encrypted file
+
+Bundles are skipped.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		root := "."
+		if len(args) > 0 {
+			root = args[0]
+		}
+
+		identities, closeIdentities, err := readUserIdentities()
+		if err != nil {
+			return fmt.Errorf("reading private key: %w", err)
+		}
+		defer closeIdentities()
+
+		state, err := loadDecryptState()
+		if err != nil {
+			return err
+		}
+
+		encPaths, err := findEncFiles(root)
+		if err != nil {
+			return err
+		}
+
+		var modified int
+		for _, encPath := range encPaths {
+			status, plainPath, err := fileStatus(encPath, identities, state)
+			if status == statusBundle {
+				continue
+			}
+			if err != nil {
+				fmt.Printf("%-20s %s: %v\n", status, encPath, err)
+				continue
+			}
+			fmt.Printf("%-20s %s -> %s\n", status, encPath, plainPath)
+			if status == statusPlaintextModified {
+				modified++
+			}
+		}
+
+		if statusCheck && modified > 0 {
+			cmd.SilenceUsage = true
+			return fmt.Errorf("%d plaintext file(s) have changes that aren't encrypted", modified)
+		}
+		return nil
+	},
+}
+
+// findEncFiles returns the paths of all encrypted files under root, skipping
+// .git directories.
+func findEncFiles(root string) ([]string, error) {
+	var paths []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if info.Mode().IsRegular() && strings.HasSuffix(path, encFileSuffix) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// plaintextPath returns the plaintext path paired with an encrypted file:
+// the path without its .devcrypt suffix, or else the stored filename next to
+// the encrypted file.
+func plaintextPath(encPath string, encFile *internal.EncFile) string {
+	plainPath := strings.TrimSuffix(encPath, encFileSuffix)
+	if _, err := os.Stat(plainPath); err == nil || encFile.Filename == "" {
+		return plainPath
+	}
+	return filepath.Join(filepath.Dir(encPath), safeBaseName(encFile.Filename))
+}
+
+// fileStatus compares an encrypted file with its plaintext.
+func fileStatus(encPath string, identities []internal.Identity, state *decryptState) (status, plainPath string, err error) {
+	encFile, err := readEncFile(encPath)
+	if err != nil {
+		return statusError, "", err
+	}
+	if encFile.IsBundle() {
+		return statusBundle, "", nil
+	}
+
+	// Unsealing decrypts any hidden Filename
+	unsealedFile, err := encFile.Unseal(identities...)
+	if errors.Is(err, internal.ErrKeyBoxNotFound) {
+		return statusNotRecipient, plaintextPath(encPath, encFile), nil
+	} else if err != nil {
+		return statusError, plaintextPath(encPath, encFile), err
+	}
+	return unsealedFileStatus(encPath, unsealedFile, state)
+}
+
+// unsealedFileStatus is fileStatus for an encrypted file that is already
+// unsealed.
+func unsealedFileStatus(encPath string, unsealedFile *internal.UnsealedEncFile, state *decryptState) (status, plainPath string, err error) {
+	plainPath = plaintextPath(encPath, unsealedFile.EncFile)
+	plaintext, err := ioutil.ReadFile(plainPath)
+	if os.IsNotExist(err) {
+		return statusPlaintextMissing, plainPath, nil
+	} else if err != nil {
+		return statusError, plainPath, err
+	}
+
+	status, err = plaintextStatus(unsealedFile, encPath, plainPath, plaintext, state)
+	return status, plainPath, err
+}
+
+// plaintextStatus compares a plaintext with its encrypted file: in sync,
+// modified locally, or unmodified since it was last decrypted or encrypted
+// but older than the encrypted file.
+func plaintextStatus(unsealedFile *internal.UnsealedEncFile, encPath, plainPath string, plaintext []byte, state *decryptState) (string, error) {
+	if bytes.Equal(unsealedFile.PlaintextMAC(plaintext), unsealedFile.MAC) {
+		return statusInSync, nil
+	}
+
+	// If the plaintext is what was last recorded, the ciphertext has moved on
+	if lastDigest := state.lastDigest(plainPath); lastDigest != nil {
+		if bytes.Equal(plaintextDigest(plaintext), lastDigest) {
+			return statusCiphertextNewer, nil
+		}
+		return statusPlaintextModified, nil
+	}
+
+	// Otherwise fall back to comparing modification times
+	if encPath == stdioPath {
+		return statusPlaintextModified, nil
+	}
+	encInfo, err := os.Stat(encPath)
+	if err != nil {
+		return statusError, err
+	}
+	plainInfo, err := os.Stat(plainPath)
+	if err != nil {
+		return statusError, err
+	}
+	if encInfo.ModTime().After(plainInfo.ModTime()) {
+		return statusCiphertextNewer, nil
+	}
+	return statusPlaintextModified, nil
+}
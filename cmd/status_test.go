@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lann/devcrypt/internal"
+)
+
+func TestFileStatus_RemoteUpdate(t *testing.T) {
+	dir := t.TempDir()
+	defer func(dir string) { configDir = dir }(configDir)
+	configDir = filepath.Join(dir, "config")
+
+	_, alice, err := internal.GenerateKeys("alice")
+	assert.NoError(t, err)
+	identities := []internal.Identity{alice}
+
+	// Alice encrypts her plaintext
+	plainPath := filepath.Join(dir, "secret")
+	encPath := plainPath + encFileSuffix
+	assert.NoError(t, ioutil.WriteFile(plainPath, []byte("v1"), 0600))
+	unsealedFile, err := internal.NewUnsealedEncFile("secret")
+	assert.NoError(t, err)
+	assert.NoError(t, unsealedFile.AddPublicKey(alice.Recipient()))
+	assert.NoError(t, unsealedFile.Encrypt([]byte("v1")))
+	assert.NoError(t, rewriteFile(encPath, unsealedFile))
+	assert.NoError(t, recordPlaintext(plainPath, []byte("v1")))
+
+	state, err := loadDecryptState()
+	assert.NoError(t, err)
+	status, _, err := fileStatus(encPath, identities, state)
+	assert.NoError(t, err)
+	assert.Equal(t, statusInSync, status)
+
+	// Bob updates the encrypted file. Alice's plaintext is newer on disk, so
+	// only the recorded digest shows that it is merely stale.
+	assert.NoError(t, unsealedFile.Encrypt([]byte("v2")))
+	assert.NoError(t, rewriteFile(encPath, unsealedFile))
+	later := time.Now().Add(time.Hour)
+	assert.NoError(t, os.Chtimes(plainPath, later, later))
+
+	status, _, err = fileStatus(encPath, identities, state)
+	assert.NoError(t, err)
+	assert.Equal(t, statusCiphertextNewer, status)
+
+	// Alice's own changes are still reported
+	assert.NoError(t, ioutil.WriteFile(plainPath, []byte("v1 edited"), 0600))
+	status, _, err = fileStatus(encPath, identities, state)
+	assert.NoError(t, err)
+	assert.Equal(t, statusPlaintextModified, status)
+}
+
+func TestFileStatus_NoRecordedDigest(t *testing.T) {
+	dir := t.TempDir()
+	defer func(dir string) { configDir = dir }(configDir)
+	configDir = filepath.Join(dir, "config")
+
+	_, alice, err := internal.GenerateKeys("alice")
+	assert.NoError(t, err)
+	identities := []internal.Identity{alice}
+
+	// A plaintext from before digests were recorded
+	plainPath := filepath.Join(dir, "secret")
+	encPath := plainPath + encFileSuffix
+	assert.NoError(t, ioutil.WriteFile(plainPath, []byte("v1"), 0600))
+	earlier := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(plainPath, earlier, earlier))
+
+	unsealedFile, err := internal.NewUnsealedEncFile("secret")
+	assert.NoError(t, err)
+	assert.NoError(t, unsealedFile.AddPublicKey(alice.Recipient()))
+	assert.NoError(t, unsealedFile.Encrypt([]byte("v2")))
+	assert.NoError(t, rewriteFile(encPath, unsealedFile))
+
+	state, err := loadDecryptState()
+	assert.NoError(t, err)
+	status, _, err := fileStatus(encPath, identities, state)
+	assert.NoError(t, err)
+	assert.Equal(t, statusCiphertextNewer, status)
+
+	later := time.Now().Add(time.Hour)
+	assert.NoError(t, os.Chtimes(plainPath, later, later))
+	status, _, err = fileStatus(encPath, identities, state)
+	assert.NoError(t, err)
+	assert.Equal(t, statusPlaintextModified, status)
+}
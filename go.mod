@@ -3,7 +3,9 @@ module github.com/lann/devcrypt
 go 1.15
 
 require (
+	github.com/klauspost/compress v1.11.4
 	github.com/spf13/cobra v1.1.1
 	github.com/stretchr/testify v1.3.0
 	golang.org/x/crypto v0.0.0-20201217014255-9d1352758620
+	golang.org/x/term v0.0.0-20201117132131-f5c789dd3221
 )
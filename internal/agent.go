@@ -0,0 +1,415 @@
+package internal
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	agentOpList      = "list"
+	agentOpAdd       = "add"
+	agentOpRemoveAll = "remove-all"
+	agentOpUnwrap    = "unwrap"
+	agentOpLock      = "lock"
+	agentOpUnlock    = "unlock"
+)
+
+var (
+	// ErrAgentLocked means the agent is locked
+	ErrAgentLocked = errors.New("agent is locked")
+
+	errAgentNoKey = errors.New("agent has no matching private key")
+)
+
+type agentRequest struct {
+	Op         string        `json:"op"`
+	KeyBox     string        `json:"keyBox,omitempty"`
+	PrivateKey []byte        `json:"privateKey,omitempty"`
+	Lifetime   time.Duration `json:"lifetime,omitempty"`
+	Passphrase string        `json:"passphrase,omitempty"`
+}
+
+type agentResponse struct {
+	Error      string   `json:"error,omitempty"`
+	PublicKeys []string `json:"publicKeys,omitempty"`
+	FileKey    []byte   `json:"fileKey,omitempty"`
+}
+
+// Agent holds PrivateKeys in memory and opens KeyBoxes for clients. It never
+// reveals the private keys themselves.
+type Agent struct {
+	mu       sync.Mutex
+	keys     []*agentKey
+	locked   bool
+	lockSalt [16]byte
+	lockHash [32]byte
+}
+
+type agentKey struct {
+	privKey *PrivateKey
+	timer   *time.Timer
+}
+
+// NewAgent returns an empty Agent.
+func NewAgent() *Agent {
+	return &Agent{}
+}
+
+// AddKey adds a PrivateKey to the Agent. If lifetime is positive the key is
+// forgotten after that duration.
+func (a *Agent) AddKey(privKey *PrivateKey, lifetime time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.addKey(privKey, lifetime)
+}
+
+// addKey must be called with a.mu held.
+func (a *Agent) addKey(privKey *PrivateKey, lifetime time.Duration) {
+	// Replace any existing copy of the key
+	pubKey := privKey.publicKey()
+	for _, key := range a.keys {
+		if *key.privKey.publicKey().key == *pubKey.key {
+			a.removeKey(key)
+			break
+		}
+	}
+
+	key := &agentKey{privKey: privKey}
+	if lifetime > 0 {
+		key.timer = time.AfterFunc(lifetime, func() {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			a.removeKey(key)
+		})
+	}
+	a.keys = append(a.keys, key)
+}
+
+// RemoveAll forgets all keys held by the Agent.
+func (a *Agent) RemoveAll() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.removeAll()
+}
+
+// removeAll must be called with a.mu held.
+func (a *Agent) removeAll() {
+	for len(a.keys) > 0 {
+		a.removeKey(a.keys[0])
+	}
+}
+
+// removeKey must be called with a.mu held.
+func (a *Agent) removeKey(key *agentKey) {
+	updated := a.keys[:0]
+	for _, k := range a.keys {
+		if k != key {
+			updated = append(updated, k)
+		}
+	}
+	a.keys = updated
+
+	if key.timer != nil {
+		key.timer.Stop()
+	}
+	// Wipe the key bytes; the PrivateKey is never shared outside the agent.
+	*key.privKey.key = [32]byte{}
+}
+
+// Lock locks the Agent with a passphrase. A locked Agent lists no keys, and
+// its clients can't open key boxes or add or remove keys, until it is
+// unlocked with the same passphrase.
+func (a *Agent) Lock(passphrase string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.locked {
+		return ErrAgentLocked
+	}
+	if _, err := rand.Read(a.lockSalt[:]); err != nil {
+		return err
+	}
+	hash, err := hashPassphrase(a.lockSalt[:], passphrase)
+	if err != nil {
+		return err
+	}
+	a.lockHash = hash
+	a.locked = true
+	return nil
+}
+
+// Unlock unlocks a locked Agent.
+func (a *Agent) Unlock(passphrase string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.locked {
+		return errors.New("agent is not locked")
+	}
+	hash, err := hashPassphrase(a.lockSalt[:], passphrase)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(hash[:], a.lockHash[:]) != 1 {
+		return errors.New("incorrect passphrase")
+	}
+	a.locked = false
+	return nil
+}
+
+// hashPassphrase stretches a lock passphrase with scrypt, using the
+// parameters recommended for interactive logins, so a leaked hash can't
+// cheaply be guessed.
+func hashPassphrase(salt []byte, passphrase string) (hash [32]byte, err error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, len(hash))
+	copy(hash[:], key)
+	return hash, err
+}
+
+// ifUnlocked calls f with a.mu held, unless the Agent is locked.
+func (a *Agent) ifUnlocked(f func()) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.locked {
+		return ErrAgentLocked
+	}
+	f()
+	return nil
+}
+
+// PublicKeys returns the public keys of the keys held by the Agent.
+func (a *Agent) PublicKeys() []*PublicKey {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.locked {
+		return nil
+	}
+	pubKeys := make([]*PublicKey, len(a.keys))
+	for i, key := range a.keys {
+		pubKeys[i] = key.privKey.publicKey()
+	}
+	return pubKeys
+}
+
+// OpenKeyBox opens the KeyBox with the matching key held by the Agent.
+func (a *Agent) OpenKeyBox(keyBox *KeyBox) (*[32]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.locked {
+		return nil, ErrAgentLocked
+	}
+	for _, key := range a.keys {
+		if *key.privKey.publicKey().key == *keyBox.key {
+			return key.privKey.OpenKeyBox(keyBox)
+		}
+	}
+	return nil, errAgentNoKey
+}
+
+// Serve accepts connections on the Listener until it is closed.
+func (a *Agent) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go a.serveConn(conn)
+	}
+}
+
+func (a *Agent) serveConn(conn net.Conn) {
+	defer conn.Close()
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	enc := json.NewEncoder(conn)
+	for {
+		var req agentRequest
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		resp := a.handle(&req)
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+func (a *Agent) handle(req *agentRequest) *agentResponse {
+	resp := &agentResponse{}
+	var err error
+	switch req.Op {
+	case agentOpList:
+		for _, pubKey := range a.PublicKeys() {
+			resp.PublicKeys = append(resp.PublicKeys, pubKey.MarshalString())
+		}
+	case agentOpAdd:
+		privKey := &PrivateKey{}
+		if err = privKey.Unmarshal(req.PrivateKey); err == nil {
+			err = a.ifUnlocked(func() { a.addKey(privKey, req.Lifetime) })
+		}
+	case agentOpRemoveAll:
+		err = a.ifUnlocked(a.removeAll)
+	case agentOpUnwrap:
+		keyBox := &KeyBox{}
+		if err = keyBox.UnmarshalString(req.KeyBox); err == nil {
+			var fileKey *[32]byte
+			if fileKey, err = a.OpenKeyBox(keyBox); err == nil {
+				resp.FileKey = fileKey[:]
+			}
+		}
+	case agentOpLock:
+		err = a.Lock(req.Passphrase)
+	case agentOpUnlock:
+		err = a.Unlock(req.Passphrase)
+	default:
+		err = fmt.Errorf("unknown op %q", req.Op)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return resp
+}
+
+// AgentClient talks to an Agent over a connection.
+type AgentClient struct {
+	mu   sync.Mutex
+	conn io.ReadWriteCloser
+	enc  *json.Encoder
+	dec  *json.Decoder
+}
+
+// DialAgent connects to an Agent listening on the given unix socket path.
+func DialAgent(path string) (*AgentClient, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return NewAgentClient(conn), nil
+}
+
+// NewAgentClient returns an AgentClient using the given connection.
+func NewAgentClient(conn io.ReadWriteCloser) *AgentClient {
+	return &AgentClient{
+		conn: conn,
+		enc:  json.NewEncoder(conn),
+		dec:  json.NewDecoder(bufio.NewReader(conn)),
+	}
+}
+
+// Close closes the connection to the Agent.
+func (c *AgentClient) Close() error {
+	return c.conn.Close()
+}
+
+func (c *AgentClient) call(req *agentRequest) (*agentResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.enc.Encode(req); err != nil {
+		return nil, fmt.Errorf("agent request: %w", err)
+	}
+	resp := &agentResponse{}
+	if err := c.dec.Decode(resp); err != nil {
+		return nil, fmt.Errorf("agent response: %w", err)
+	}
+	if resp.Error != "" {
+		if resp.Error == ErrAgentLocked.Error() {
+			return nil, ErrAgentLocked
+		}
+		return nil, fmt.Errorf("agent: %s", resp.Error)
+	}
+	return resp, nil
+}
+
+// PublicKeys returns the public keys of the keys held by the Agent.
+func (c *AgentClient) PublicKeys() ([]*PublicKey, error) {
+	resp, err := c.call(&agentRequest{Op: agentOpList})
+	if err != nil {
+		return nil, err
+	}
+	pubKeys := make([]*PublicKey, len(resp.PublicKeys))
+	for i, line := range resp.PublicKeys {
+		pubKeys[i] = &PublicKey{}
+		if err := pubKeys[i].UnmarshalString(line); err != nil {
+			return nil, err
+		}
+	}
+	return pubKeys, nil
+}
+
+// Identities returns an Identity for each key held by the Agent.
+func (c *AgentClient) Identities() ([]Identity, error) {
+	pubKeys, err := c.PublicKeys()
+	if err != nil {
+		return nil, err
+	}
+	identities := make([]Identity, len(pubKeys))
+	for i, pubKey := range pubKeys {
+		identities[i] = &agentIdentity{client: c, pubKey: pubKey}
+	}
+	return identities, nil
+}
+
+// AddKey adds a PrivateKey to the Agent. If lifetime is positive the Agent
+// forgets the key after that duration.
+func (c *AgentClient) AddKey(privKey *PrivateKey, lifetime time.Duration) error {
+	privKeyEnc, err := privKey.Marshal()
+	if err != nil {
+		return err
+	}
+	_, err = c.call(&agentRequest{
+		Op:         agentOpAdd,
+		PrivateKey: privKeyEnc,
+		Lifetime:   lifetime,
+	})
+	return err
+}
+
+// RemoveAll makes the Agent forget all of its keys.
+func (c *AgentClient) RemoveAll() error {
+	_, err := c.call(&agentRequest{Op: agentOpRemoveAll})
+	return err
+}
+
+// Lock locks the Agent with a passphrase.
+func (c *AgentClient) Lock(passphrase string) error {
+	_, err := c.call(&agentRequest{Op: agentOpLock, Passphrase: passphrase})
+	return err
+}
+
+// Unlock unlocks the Agent with a passphrase.
+func (c *AgentClient) Unlock(passphrase string) error {
+	_, err := c.call(&agentRequest{Op: agentOpUnlock, Passphrase: passphrase})
+	return err
+}
+
+// agentIdentity is an Identity backed by a key held in an Agent.
+type agentIdentity struct {
+	client *AgentClient
+	pubKey *PublicKey
+}
+
+func (id *agentIdentity) Recipient() *PublicKey {
+	return id.pubKey
+}
+
+func (id *agentIdentity) OpenKeyBox(keyBox *KeyBox) (*[32]byte, error) {
+	resp, err := id.client.call(&agentRequest{
+		Op:     agentOpUnwrap,
+		KeyBox: keyBox.MarshalString(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var fileKey [32]byte
+	if copy(fileKey[:], resp.FileKey) != len(fileKey) {
+		return nil, errors.New("agent returned a bad file key")
+	}
+	return &fileKey, nil
+}
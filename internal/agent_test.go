@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAgent_Unseal(t *testing.T) {
+	unsealedFile, pubKey, privKey := generateTestUnsealedEncFile(t)
+	client := startTestAgent(t, privKey, 0)
+
+	identities, err := client.Identities()
+	assert.NoError(t, err)
+	if assert.Len(t, identities, 1) {
+		assert.Equal(t, pubKey.key, identities[0].Recipient().key)
+	}
+
+	agentUnsealed, err := unsealedFile.EncFile.Unseal(identities[0])
+	assert.NoError(t, err)
+
+	plaintext, err := agentUnsealed.Decrypt()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("testData"), plaintext)
+}
+
+func TestAgent_Lock(t *testing.T) {
+	unsealedFile, _, privKey := generateTestUnsealedEncFile(t)
+	client := startTestAgent(t, privKey, 0)
+
+	identities, err := client.Identities()
+	assert.NoError(t, err)
+
+	assert.NoError(t, client.Lock("passphrase"))
+
+	_, err = unsealedFile.EncFile.Unseal(identities[0])
+	assert.Equal(t, ErrAgentLocked, err)
+
+	pubKeys, err := client.PublicKeys()
+	assert.NoError(t, err)
+	assert.Empty(t, pubKeys)
+
+	// Keys can't be added or removed either
+	_, _, otherKey := generateTestUnsealedEncFile(t)
+	assert.Equal(t, ErrAgentLocked, client.AddKey(otherKey, 0))
+	assert.Equal(t, ErrAgentLocked, client.RemoveAll())
+
+	assert.Error(t, client.Unlock("wrong"))
+	assert.NoError(t, client.Unlock("passphrase"))
+
+	_, err = unsealedFile.EncFile.Unseal(identities[0])
+	assert.NoError(t, err)
+	pubKeys, err = client.PublicKeys()
+	assert.NoError(t, err)
+	assert.Len(t, pubKeys, 1)
+}
+
+func TestAgent_Lifetime(t *testing.T) {
+	_, _, privKey := generateTestUnsealedEncFile(t)
+	client := startTestAgent(t, privKey, 10*time.Millisecond)
+
+	pubKeys, err := client.PublicKeys()
+	assert.NoError(t, err)
+	assert.Len(t, pubKeys, 1)
+
+	time.Sleep(50 * time.Millisecond)
+	pubKeys, err = client.PublicKeys()
+	assert.NoError(t, err)
+	assert.Empty(t, pubKeys)
+}
+
+func startTestAgent(t *testing.T, privKey *PrivateKey, lifetime time.Duration) *AgentClient {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "devcrypt-agent-test")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	socketPath := filepath.Join(dir, "agent.sock")
+	l, err := net.Listen("unix", socketPath)
+	assert.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	agent := NewAgent()
+	go agent.Serve(l)
+
+	client, err := DialAgent(socketPath)
+	assert.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+
+	// Send the key through the client to exercise the add op
+	assert.NoError(t, client.AddKey(privKey, lifetime))
+	return client
+}
@@ -0,0 +1,196 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The binary encoding holds the same key boxes and blocks as the PEM armored
+// text, without the base64 overhead:
+//
+//	magic
+//	uvarint key box count, then for each: uvarint length, key box line
+//	uvarint block count, then for each:
+//	  uvarint length, block type
+//	  uvarint header count, then for each (sorted by name):
+//	    uvarint length, name, uvarint length, value
+//	  uvarint length, raw ciphertext
+//
+// Converting between the encodings doesn't change any authenticated data.
+const (
+	binaryMagic = "DEVCRYPT\x00\x01"
+
+	// maxBinaryFieldLen limits every length except the ciphertext's
+	maxBinaryFieldLen = 64 * 1024
+)
+
+var (
+	errBinaryFieldTooLong = errors.New("binary field too long")
+)
+
+func (f *EncFile) writeBinary(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	buf.WriteString(binaryMagic)
+
+	writeUvarint(&buf, uint64(len(f.keyBoxes)))
+	for _, keyBox := range f.keyBoxes {
+		writeBinaryField(&buf, []byte(keyBox.MarshalString()))
+	}
+
+	blocks := f.blocks()
+	writeUvarint(&buf, uint64(len(blocks)))
+	for _, block := range blocks {
+		writeBinaryField(&buf, []byte(block.Type))
+		names := sortedHeaderNames(block.Headers)
+		writeUvarint(&buf, uint64(len(names)))
+		for _, name := range names {
+			writeBinaryField(&buf, []byte(name))
+			writeBinaryField(&buf, []byte(block.Headers[name]))
+		}
+		writeBinaryField(&buf, block.Bytes)
+	}
+
+	return buf.WriteTo(w)
+}
+
+func (f *EncFile) readBinary(br *bufio.Reader) (int64, error) {
+	r := &binaryReader{r: br}
+	magic := make([]byte, len(binaryMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return r.n, err
+	}
+
+	keyBoxCount, err := r.readCount()
+	if err != nil {
+		return r.n, fmt.Errorf("reading key box count: %w", err)
+	}
+	if keyBoxCount > maxKeyBoxes {
+		return r.n, errTooManyKeyBoxes
+	}
+	for i := 0; i < keyBoxCount; i++ {
+		line, err := r.readField(maxBinaryFieldLen)
+		if err != nil {
+			return r.n, fmt.Errorf("reading key box %d: %w", i+1, err)
+		}
+		keyBox := &KeyBox{}
+		if err := keyBox.UnmarshalString(string(line)); err != nil {
+			return r.n, fmt.Errorf("%w (key box %d)", err, i+1)
+		}
+		f.keyBoxes = append(f.keyBoxes, keyBox)
+	}
+
+	blockCount, err := r.readCount()
+	if err != nil {
+		return r.n, fmt.Errorf("reading block count: %w", err)
+	}
+	var blocks []*pem.Block
+	for i := 0; i < blockCount; i++ {
+		block, err := r.readBlock()
+		if err != nil {
+			return r.n, fmt.Errorf("reading block %d: %w", i+1, err)
+		}
+		blocks = append(blocks, block)
+	}
+
+	if _, err := br.Peek(1); err != io.EOF {
+		return r.n, errBadEncFileEncoding
+	}
+	return r.n, f.parseBlocks(blocks)
+}
+
+// binaryReader reads the fields of the binary encoding, counting bytes read.
+type binaryReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (r *binaryReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func (r *binaryReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.n++
+	}
+	return b, err
+}
+
+func (r *binaryReader) readCount() (int, error) {
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, unexpectedEOF(err)
+	}
+	if count > maxBinaryFieldLen {
+		return 0, errBinaryFieldTooLong
+	}
+	return int(count), nil
+}
+
+// readField reads a length-prefixed field. The buffer grows as data arrives,
+// so a bogus length can't allocate more than the input holds.
+func (r *binaryReader) readField(maxLen uint64) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if length > maxLen {
+		return nil, errBinaryFieldTooLong
+	}
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(length)); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (r *binaryReader) readBlock() (*pem.Block, error) {
+	blockType, err := r.readField(maxBinaryFieldLen)
+	if err != nil {
+		return nil, err
+	}
+	block := &pem.Block{Type: string(blockType), Headers: map[string]string{}}
+
+	headerCount, err := r.readCount()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < headerCount; i++ {
+		name, err := r.readField(maxBinaryFieldLen)
+		if err != nil {
+			return nil, err
+		}
+		value, err := r.readField(maxBinaryFieldLen)
+		if err != nil {
+			return nil, err
+		}
+		block.Headers[string(name)] = string(value)
+	}
+
+	block.Bytes, err = r.readField(maxEncFileSize)
+	return block, err
+}
+
+func writeUvarint(buf *bytes.Buffer, x uint64) {
+	var varint [binary.MaxVarintLen64]byte
+	buf.Write(varint[:binary.PutUvarint(varint[:], x)])
+}
+
+func writeBinaryField(buf *bytes.Buffer, data []byte) {
+	writeUvarint(buf, uint64(len(data)))
+	buf.Write(data)
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncFile_BinaryRoundTrip(t *testing.T) {
+	unsealedFile, _, privKey := generateTestUnsealedEncFile(t)
+
+	armored := &bytes.Buffer{}
+	_, err := unsealedFile.WriteTo(armored)
+	assert.NoError(t, err)
+
+	// Dearmor
+	encFile := &EncFile{}
+	_, err = encFile.ReadFrom(bytes.NewReader(armored.Bytes()))
+	assert.NoError(t, err)
+	assert.False(t, encFile.Binary)
+	encFile.Binary = true
+	binaryBuf := &bytes.Buffer{}
+	n, err := encFile.WriteTo(binaryBuf)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(binaryBuf.Len()), n)
+	assert.True(t, bytes.HasPrefix(binaryBuf.Bytes(), []byte(binaryMagic)))
+	assert.True(t, binaryBuf.Len() < armored.Len())
+
+	// Read binary and unseal
+	binaryFile := &EncFile{}
+	n, err = binaryFile.ReadFrom(bytes.NewReader(binaryBuf.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(binaryBuf.Len()), n)
+	assert.True(t, binaryFile.Binary)
+	unsealed, err := binaryFile.Unseal(privKey)
+	assert.NoError(t, err)
+	plaintext, err := unsealed.Decrypt()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("testData"), plaintext)
+
+	// Armor again, losslessly
+	binaryFile.Binary = false
+	rearmored := &bytes.Buffer{}
+	_, err = binaryFile.WriteTo(rearmored)
+	assert.NoError(t, err)
+	assert.Equal(t, armored.String(), rearmored.String())
+}
+
+func TestEncFile_BinaryTruncated(t *testing.T) {
+	unsealedFile, _, _ := generateTestUnsealedEncFile(t)
+	unsealedFile.Binary = true
+
+	buf := &bytes.Buffer{}
+	_, err := unsealedFile.WriteTo(buf)
+	assert.NoError(t, err)
+
+	encFile := &EncFile{}
+	_, err = encFile.ReadFrom(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF), "%v", err)
+
+	_, err = encFile.ReadFrom(bytes.NewReader(append(buf.Bytes(), 0)))
+	assert.Equal(t, errBadEncFileEncoding, err)
+}
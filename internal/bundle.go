@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	bundleBlockType      = "DEVCRYPT BUNDLE"
+	bundleEntryBlockType = "DEVCRYPT BUNDLE ENTRY"
+)
+
+var (
+	// ErrEntryNotFound means the bundle has no entry with the given name
+	ErrEntryNotFound = errors.New("bundle entry not found")
+
+	// ErrNotBundle means the file isn't a bundle
+	ErrNotBundle = errors.New("file is not a bundle")
+
+	errBundleIndexMismatch = errors.New("bundle index doesn't match its entries")
+	errEntryMACMismatch    = errors.New("bundle entry MAC mismatch")
+)
+
+// NewUnsealedBundle initializes a new bundle with no entries.
+//
+// A bundle stores many named entries under one file key and set of key
+// boxes. Each entry is encrypted separately, so one can be decrypted without
+// the others. The bundle's own ciphertext is an index of entry names and
+// MACs, so entries can't be removed or swapped without the file key.
+func NewUnsealedBundle() (*UnsealedEncFile, error) {
+	return newUnsealedBundle(rand.Reader)
+}
+
+func newUnsealedBundle(rand io.Reader) (*UnsealedEncFile, error) {
+	f, err := newUnsealedEncFile("", rand)
+	if err != nil {
+		return nil, err
+	}
+	f.bundle = true
+	if err := f.encrypt(f.bundleIndex()); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// IsBundle returns true if the EncFile is a bundle.
+func (f *EncFile) IsBundle() bool {
+	return f.bundle
+}
+
+// EntryNames returns the names of a bundle's entries. If the bundle hides
+// its metadata, the names are only known once it is unsealed.
+func (f *EncFile) EntryNames() []string {
+	names := make([]string, len(f.entries))
+	for i, entry := range f.entries {
+		names[i] = entry.Filename
+	}
+	return names
+}
+
+// ReadEntry decrypts the named bundle entry.
+func (f *UnsealedEncFile) ReadEntry(name string) ([]byte, error) {
+	i, ok := f.findEntry(name)
+	if !ok {
+		return nil, ErrEntryNotFound
+	}
+	entry := f.entry(f.entries[i])
+	plaintext, err := entry.decrypt()
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(entry.PlaintextMAC(plaintext), entry.MAC) {
+		return nil, errEntryMACMismatch
+	}
+	return plaintext, nil
+}
+
+// WriteEntry encrypts plaintext into the named bundle entry, replacing any
+// existing entry with that name.
+func (f *UnsealedEncFile) WriteEntry(name string, plaintext []byte) error {
+	if !f.bundle {
+		return ErrNotBundle
+	}
+	if name == "" || strings.ContainsAny(name, "\r\n") {
+		return fmt.Errorf("invalid bundle entry name %q", name)
+	}
+
+	entry := &EncFile{Filename: name, Padding: f.Padding, Compression: f.Compression, Deterministic: f.Deterministic}
+	if err := f.entry(entry).encrypt(plaintext); err != nil {
+		return err
+	}
+
+	if i, ok := f.findEntry(name); ok {
+		f.entries[i] = entry
+	} else {
+		f.entries = append(f.entries, entry)
+		sort.Slice(f.entries, func(i, j int) bool {
+			return f.entries[i].Filename < f.entries[j].Filename
+		})
+	}
+	return f.encrypt(f.bundleIndex())
+}
+
+// RemoveEntry removes the named bundle entry.
+func (f *UnsealedEncFile) RemoveEntry(name string) error {
+	i, ok := f.findEntry(name)
+	if !ok {
+		return ErrEntryNotFound
+	}
+	f.entries = append(f.entries[:i], f.entries[i+1:]...)
+	return f.encrypt(f.bundleIndex())
+}
+
+func (f *UnsealedEncFile) findEntry(name string) (int, bool) {
+	for i, entry := range f.entries {
+		if entry.Filename == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// entry returns the given bundle entry, unsealed with the bundle's file key.
+func (f *UnsealedEncFile) entry(entry *EncFile) *UnsealedEncFile {
+	return &UnsealedEncFile{EncFile: entry, fileKey: f.fileKey, rand: f.rand}
+}
+
+// bundleIndex lists the bundle's entries, one "<MAC> <name>" per line.
+func (f *UnsealedEncFile) bundleIndex() []byte {
+	var buf bytes.Buffer
+	for _, entry := range f.entries {
+		fmt.Fprintf(&buf, "%x %s\n", entry.MAC, entry.Filename)
+	}
+	return buf.Bytes()
+}
+
+// verifyBundle authenticates each entry's headers and checks them against
+// the bundle's index.
+func (f *UnsealedEncFile) verifyBundle() error {
+	for _, entry := range f.entries {
+		if err := entry.verifyHeaders(f.fileKey); err != nil {
+			return fmt.Errorf("bundle entry: %w", err)
+		}
+		if len(entry.headerMAC) == 0 {
+			return fmt.Errorf("bundle entry %q has no HeaderMAC", entry.Filename)
+		}
+	}
+
+	index, err := f.decrypt()
+	if err != nil {
+		return fmt.Errorf("decrypting bundle index: %w", err)
+	}
+	if !hmac.Equal(f.PlaintextMAC(index), f.MAC) || !bytes.Equal(index, f.bundleIndex()) {
+		return errBundleIndexMismatch
+	}
+	return nil
+}
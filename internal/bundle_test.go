@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func generateTestBundle(t *testing.T) (*UnsealedEncFile, *PrivateKey) {
+	t.Helper()
+
+	pubKey, privKey, err := GenerateKeys("testLabel")
+	assert.NoError(t, err)
+
+	bundle, err := NewUnsealedBundle()
+	assert.NoError(t, err)
+	assert.NoError(t, bundle.AddPublicKey(pubKey))
+	assert.NoError(t, bundle.WriteEntry("b.env", []byte("B=2")))
+	assert.NoError(t, bundle.WriteEntry("a.env", []byte("A=1")))
+	return bundle, privKey
+}
+
+func TestBundle_RoundTrip(t *testing.T) {
+	bundle, privKey := generateTestBundle(t)
+
+	buf := &bytes.Buffer{}
+	_, err := bundle.WriteTo(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(buf.String(), "-----BEGIN DEVCRYPT BUNDLE ENTRY-----"))
+
+	encFile := &EncFile{}
+	_, err = encFile.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.True(t, encFile.IsBundle())
+	assert.Equal(t, []string{"a.env", "b.env"}, encFile.EntryNames())
+
+	unsealed, err := encFile.Unseal(privKey)
+	assert.NoError(t, err)
+
+	plaintext, err := unsealed.ReadEntry("b.env")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("B=2"), plaintext)
+
+	_, err = unsealed.ReadEntry("c.env")
+	assert.Equal(t, ErrEntryNotFound, err)
+
+	_, err = unsealed.Decrypt()
+	assert.Equal(t, ErrBundle, err)
+}
+
+func TestBundle_RemoveEntry(t *testing.T) {
+	bundle, _ := generateTestBundle(t)
+
+	assert.NoError(t, bundle.RemoveEntry("a.env"))
+	assert.Equal(t, []string{"b.env"}, bundle.EntryNames())
+	assert.Equal(t, ErrEntryNotFound, bundle.RemoveEntry("a.env"))
+}
+
+func TestBundle_DroppedEntry(t *testing.T) {
+	bundle, privKey := generateTestBundle(t)
+
+	buf := &bytes.Buffer{}
+	_, err := bundle.WriteTo(buf)
+	assert.NoError(t, err)
+
+	// Drop the last entry block
+	data := buf.String()
+	data = data[:strings.LastIndex(data, "-----BEGIN DEVCRYPT BUNDLE ENTRY-----")]
+
+	encFile := &EncFile{}
+	_, err = encFile.ReadFrom(strings.NewReader(data))
+	assert.NoError(t, err)
+
+	_, err = encFile.Unseal(privKey)
+	assert.Equal(t, errBundleIndexMismatch, err)
+}
+
+func TestBundle_HideMetadataRotate(t *testing.T) {
+	bundle, privKey := generateTestBundle(t)
+	bundle.HideMetadata = true
+	assert.NoError(t, bundle.RotateFileKey())
+
+	buf := &bytes.Buffer{}
+	_, err := bundle.WriteTo(buf)
+	assert.NoError(t, err)
+	assert.NotContains(t, buf.String(), "a.env")
+
+	encFile := &EncFile{}
+	_, err = encFile.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"", ""}, encFile.EntryNames())
+
+	unsealed, err := encFile.Unseal(privKey)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.env", "b.env"}, unsealed.EntryNames())
+
+	plaintext, err := unsealed.ReadEntry("a.env")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("A=1"), plaintext)
+}
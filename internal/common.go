@@ -5,36 +5,217 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 )
 
 var (
 	errUnexpectedNewline = errors.New("unexpected newline")
+
+	// timeNow is the clock for checking key expiry. Tests replace it.
+	timeNow = time.Now
 )
 
+// ParseError describes where parsing attacker-controllable content failed.
+// Line and Column count from 1; Column is 0 if unknown.
+type ParseError struct {
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	if e.Column > 0 {
+		return fmt.Sprintf("line %d, column %d: %v", e.Line, e.Column, e.Err)
+	}
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// atLine sets the line of err's ParseError, or wraps err in one.
+func atLine(err error, line int) error {
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) {
+		parseErr.Line = line
+		return err
+	}
+	return &ParseError{Line: line, Err: err}
+}
+
+// base64Strict rejects the non-canonical encodings that would let one key
+// have several encodings.
+var base64Strict = base64.StdEncoding.Strict()
+
 func decodeBase64Key(key *[32]byte, data string) error {
-	n, err := base64.StdEncoding.Decode(key[:], []byte(data))
+	decoded, err := base64Strict.DecodeString(data)
 	if err != nil {
 		return err
 	}
-	if n != len(key) {
-		return fmt.Errorf("key too short: %d", n)
+	if len(decoded) != len(key) {
+		return fmt.Errorf("key must be %d bytes, got %d", len(key), len(decoded))
 	}
+	copy(key[:], decoded)
 	return nil
 }
 
+// splitLineFields splits a single line into fieldCount fields after the
+// expected first field; the last field may contain spaces.
 func splitLineFields(line, firstFieldExpect string, fieldCount int) ([]string, error) {
 	fieldCount++ // arg doesn't include first field
 	line = strings.TrimSpace(line)
-	if strings.ContainsRune(line, '\n') {
-		return nil, errUnexpectedNewline
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		return nil, &ParseError{Line: 1, Column: i + 1, Err: errUnexpectedNewline}
 	}
 
 	fields := strings.SplitN(line, " ", fieldCount)
-	if len(fields) != fieldCount {
-		return nil, fmt.Errorf("expected %d fields, got %d", fieldCount, len(fields))
-	}
 	if fields[0] != firstFieldExpect {
-		return nil, fmt.Errorf("expected %q, got %q", firstFieldExpect, fields[0])
+		return nil, &ParseError{Line: 1, Column: 1, Err: fmt.Errorf("expected %q, got %q", firstFieldExpect, fields[0])}
+	}
+	if len(fields) != fieldCount {
+		return nil, &ParseError{Line: 1, Column: len(line) + 1, Err: fmt.Errorf("expected %d fields, got %d", fieldCount, len(fields))}
 	}
 	return fields[1:], nil
 }
+
+// fieldError returns a ParseError at the start of the given field of line
+// (as split by splitLineFields), or at the corrupt byte of a base64 field.
+func fieldError(line string, field int, err error) error {
+	trimmed := strings.TrimLeft(line, " \t\r\n")
+	column := len(line) - len(trimmed) + 1
+	for i := 0; i < field; i++ {
+		sep := strings.IndexByte(trimmed, ' ')
+		if sep < 0 {
+			break
+		}
+		column += sep + 1
+		trimmed = trimmed[sep+1:]
+	}
+	var corrupt base64.CorruptInputError
+	if errors.As(err, &corrupt) {
+		column += int(corrupt)
+	}
+	return &ParseError{Line: 1, Column: column, Err: err}
+}
+
+// option is a name=value pair in an authorized_keys style options prefix.
+type option struct {
+	name, value string
+}
+
+// formatOptions encodes options with non-empty values as a prefix for a
+// line, like `expires=2030-01-01T00:00:00Z,comment="CI key" `. Values with
+// spaces, commas or quotes are double-quoted.
+func formatOptions(options []option) string {
+	var sb strings.Builder
+	for _, opt := range options {
+		if opt.value == "" {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(opt.name + "=")
+		if strings.ContainsAny(opt.value, " \t,\"\\") {
+			sb.WriteString(`"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(opt.value) + `"`)
+		} else {
+			sb.WriteString(opt.value)
+		}
+	}
+	if sb.Len() > 0 {
+		sb.WriteByte(' ')
+	}
+	return sb.String()
+}
+
+// splitOptions splits any options prefix from a line whose first field
+// would otherwise be one of firstFields. It returns the options by name and
+// the rest of the line, which starts at the given offset.
+func splitOptions(line string, firstFields ...string) (options map[string]string, rest string, offset int, err error) {
+	trimmed := strings.TrimLeft(line, " \t")
+	for _, firstField := range firstFields {
+		if strings.HasPrefix(trimmed, firstField+" ") || trimmed == firstField {
+			return nil, line, 0, nil
+		}
+	}
+	firstField := `"` + strings.Join(firstFields, `" or "`) + `"`
+	offset = len(line) - len(trimmed)
+
+	options = map[string]string{}
+	i := offset
+	for {
+		start := i
+		eq := strings.IndexByte(line[i:], '=')
+		if eq <= 0 {
+			return nil, "", 0, &ParseError{Line: 1, Column: start + 1, Err: fmt.Errorf("expected %s or options", firstField)}
+		}
+		name := line[i : i+eq]
+		if strings.ContainsAny(name, " \t,\"") {
+			return nil, "", 0, &ParseError{Line: 1, Column: start + 1, Err: fmt.Errorf("invalid option name %q", name)}
+		}
+		i += eq + 1
+
+		var value strings.Builder
+		if i < len(line) && line[i] == '"' {
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\n' {
+					return nil, "", 0, &ParseError{Line: 1, Column: i + 1, Err: errUnexpectedNewline}
+				}
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+				}
+				value.WriteByte(line[i])
+			}
+			if i == len(line) {
+				return nil, "", 0, &ParseError{Line: 1, Column: start + 1, Err: fmt.Errorf("unterminated quote in option %q", name)}
+			}
+			i++
+		} else {
+			for ; i < len(line) && !strings.ContainsRune(" \t,", rune(line[i])); i++ {
+				value.WriteByte(line[i])
+			}
+		}
+		if _, ok := options[name]; ok {
+			return nil, "", 0, &ParseError{Line: 1, Column: start + 1, Err: fmt.Errorf("duplicate option %q", name)}
+		}
+		options[name] = value.String()
+
+		if i < len(line) && line[i] == ',' {
+			i++
+			continue
+		}
+		if i == len(line) || line[i] != ' ' {
+			return nil, "", 0, &ParseError{Line: 1, Column: i + 1, Err: fmt.Errorf("expected %s after options", firstField)}
+		}
+		return options, line[i+1:], i + 1, nil
+	}
+}
+
+// offsetColumn shifts the column of err's ParseError by offset.
+func offsetColumn(err error, offset int) error {
+	var parseErr *ParseError
+	if offset > 0 && errors.As(err, &parseErr) && parseErr.Column > 0 {
+		parseErr.Column += offset
+	}
+	return err
+}
+
+// parseOptionTime parses an option's RFC 3339 time.
+func parseOptionTime(name, value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("option %s: %w", name, err)
+	}
+	return t, nil
+}
+
+// formatOptionTime formats a time for an option, or "" for the zero time.
+func formatOptionTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// CompressionGzip compresses plaintext with gzip.
+	CompressionGzip = "gzip"
+
+	// CompressionZstd compresses plaintext with Zstandard.
+	CompressionZstd = "zstd"
+
+	// maxDecompressedSize bounds decompression when the original size is hidden
+	maxDecompressedSize = 2 << 30
+)
+
+// ValidateCompression checks a compression mode: "" for none,
+// CompressionGzip or CompressionZstd.
+func ValidateCompression(mode string) error {
+	switch mode {
+	case "", CompressionGzip, CompressionZstd:
+		return nil
+	}
+	return fmt.Errorf("unknown compression mode %q", mode)
+}
+
+// compress compresses plaintext with the given mode.
+func compress(plaintext []byte, mode string) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch mode {
+	case "":
+		return plaintext, nil
+	case CompressionGzip:
+		w = gzip.NewWriter(&buf)
+	case CompressionZstd:
+		var err error
+		w, err = zstd.NewWriter(&buf)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ValidateCompression(mode)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress reverses compress. If size isn't negative, the output must be
+// exactly that long, which also bounds the memory used. Otherwise it may be
+// no longer than maxDecompressedSize.
+func decompress(compressed []byte, mode string, size int64) ([]byte, error) {
+	var r io.Reader
+	switch mode {
+	case "":
+		return compressed, nil
+	case CompressionGzip:
+		gr, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		r = gr
+	case CompressionZstd:
+		zr, err := zstd.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return nil, ValidateCompression(mode)
+	}
+
+	limit := size
+	if limit < 0 {
+		limit = maxDecompressedSize
+	}
+	plaintext, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("decompressing: %w", err)
+	}
+	if size >= 0 && int64(len(plaintext)) != size {
+		return nil, fmt.Errorf("decompressed size %d doesn't match header size %d", len(plaintext), size)
+	}
+	if int64(len(plaintext)) > limit {
+		return nil, fmt.Errorf("decompressed size exceeds %d bytes", limit)
+	}
+	return plaintext, nil
+}
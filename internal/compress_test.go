@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompress(t *testing.T) {
+	plaintext := []byte(strings.Repeat(`{"key": "value"}`, 1000))
+	for _, mode := range []string{"", CompressionGzip, CompressionZstd} {
+		compressed, err := compress(plaintext, mode)
+		assert.NoError(t, err)
+		if mode != "" {
+			assert.True(t, len(compressed) < len(plaintext)/10, "mode %q", mode)
+		}
+
+		decompressed, err := decompress(compressed, mode, int64(len(plaintext)))
+		assert.NoError(t, err)
+		assert.Equal(t, plaintext, decompressed)
+
+		if mode != "" {
+			_, err = decompress(compressed, mode, int64(len(plaintext)-1))
+			assert.Error(t, err, "mode %q", mode)
+		}
+	}
+}
+
+func TestEncFile_Compression(t *testing.T) {
+	unsealedFile, _, privKey := generateTestUnsealedEncFile(t)
+	unsealedFile.Compression = CompressionGzip
+	plaintext := []byte(strings.Repeat("testData", 1000))
+	assert.NoError(t, unsealedFile.Encrypt(plaintext))
+
+	buf := &bytes.Buffer{}
+	_, err := unsealedFile.WriteTo(buf)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "Compression: gzip\n")
+
+	encFile := &EncFile{}
+	_, err = encFile.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(plaintext)), encFile.OriginalSize())
+	assert.True(t, encFile.FileSize() < len(plaintext)/10)
+
+	unsealed, err := encFile.Unseal(privKey)
+	assert.NoError(t, err)
+	decrypted, err := unsealed.Decrypt()
+	assert.NoError(t, err)
+	assert.Equal(t, plaintext, decrypted)
+}
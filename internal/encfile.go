@@ -13,6 +13,9 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
+	"strings"
+	"time"
 
 	"golang.org/x/crypto/nacl/box"
 	"golang.org/x/crypto/nacl/secretbox"
@@ -25,6 +28,11 @@ const (
 	// https://pkg.go.dev/golang.org/x/crypto/nacl/secretbox
 	chunkSize       = 16 * 1024
 	cipherChunkSize = chunkSize + secretbox.Overhead
+
+	// Limits on parsing encrypted files, which needn't come from a recipient
+	maxLineLength  = 4096
+	maxKeyBoxes    = 4096
+	maxEncFileSize = 2 << 30
 )
 
 var (
@@ -34,9 +42,69 @@ var (
 	// ErrPublicKeyNotFound means the public key wasn't found
 	ErrPublicKeyNotFound = errors.New("public key not found")
 
+	// ErrTooFewShares means removing a share holder would leave too few key
+	// shares to recover the file key
+	ErrTooFewShares = errors.New("too few key shares would remain")
+
+	// ErrBundle means the file is a bundle, whose entries must be encrypted
+	// and decrypted individually
+	ErrBundle = errors.New("file is a bundle")
+
+	// ErrKeyBoxNotFound means there is no key box for an Identity
+	ErrKeyBoxNotFound = errors.New("no key box found")
+
 	errBadEncFileEncoding = errors.New("invalid encrypted file encoding")
+	errMissingBlock       = errors.New("missing encrypted file block")
+	errLineTooLong        = errors.New("line too long")
+	errTooManyKeyBoxes    = errors.New("too many key boxes")
+	errMetadataNewline    = errors.New("key box metadata may not contain newlines")
+	errAnonymousMetadata  = errors.New("anonymous key boxes can't have metadata")
+	errThresholdExists    = errors.New("file already has threshold recipients")
+	errEncFileTooLarge    = errors.New("encrypted file too large")
 )
 
+// NoKeyBoxError means none of the Identities given to Unseal has a key box.
+type NoKeyBoxError struct {
+	Recipients []*PublicKey
+	Tried      []*PublicKey
+
+	// Anonymous is the number of anonymous key boxes that couldn't be opened
+	Anonymous int
+
+	// Shares is the number of shares of the file key the Tried keys hold,
+	// fewer than the Threshold needed to recover it.
+	Shares, Threshold int
+}
+
+func (e *NoKeyBoxError) Error() string {
+	var sb strings.Builder
+	if len(e.Tried) == 1 {
+		fmt.Fprintf(&sb, "%s for key labeled %q", ErrKeyBoxNotFound, e.Tried[0].Label)
+	} else {
+		fmt.Fprintf(&sb, "%s for any of %d keys", ErrKeyBoxNotFound, len(e.Tried))
+	}
+	sb.WriteString("\nTried keys:")
+	for _, pubKey := range e.Tried {
+		sb.WriteString("\n  " + pubKey.MarshalString())
+	}
+	sb.WriteString("\nFile recipients:")
+	for _, pubKey := range e.Recipients {
+		sb.WriteString("\n  " + pubKey.MarshalString())
+	}
+	if e.Anonymous > 0 {
+		fmt.Fprintf(&sb, "\n  (and %d anonymous)", e.Anonymous)
+	}
+	if e.Threshold > 0 {
+		fmt.Fprintf(&sb, "\nThe file key is split into shares; %d are needed to unseal it and the tried keys hold %d", e.Threshold, e.Shares)
+	}
+	return sb.String()
+}
+
+// Unwrap returns ErrKeyBoxNotFound.
+func (e *NoKeyBoxError) Unwrap() error {
+	return ErrKeyBoxNotFound
+}
+
 // EncFile stores KeyBoxes and an encrypted file.
 type EncFile struct {
 	keyBoxes []*KeyBox
@@ -44,22 +112,90 @@ type EncFile struct {
 	Filename string
 	MAC      []byte
 
+	// Optional metadata; zero values aren't stored
+	Mode    os.FileMode
+	ModTime time.Time
+
+	// Padding is applied to the plaintext before encryption; see
+	// ValidatePadding.
+	Padding string
+
+	// Compression is applied to the plaintext before padding and
+	// encryption; see ValidateCompression.
+	Compression string
+
+	// Binary selects the compact binary encoding instead of PEM armor. It
+	// is set by ReadFrom to match the input.
+	Binary bool
+
+	// HideMetadata encrypts the Filename and other metadata. They are only
+	// available once the EncFile is unsealed.
+	HideMetadata bool
+
+	// Deterministic derives nonces from the file key and what they seal,
+	// rather than generating them randomly. Re-encrypting an unchanged
+	// plaintext or metadata under the same file key then reproduces the
+	// same ciphertext, at the cost of revealing that it is unchanged.
+	Deterministic bool
+
 	nonce      []byte
 	ciphertext []byte
+	metadata   []byte
+	headerMAC  []byte
+
+	// originalSize is the uncompressed plaintext size, or -1 if unknown
+	originalSize int64
+
+	// A bundle's ciphertext is an index of its entries; see bundle.go
+	bundle  bool
+	entries []*EncFile
 }
 
-// PublicKeys returns the public keys in this EncFile.
+// PublicKeys returns the known public keys in this EncFile. Anonymous key
+// boxes' public keys are only known once the EncFile is unsealed.
 func (f *EncFile) PublicKeys() []*PublicKey {
-	pubKeys := make([]*PublicKey, len(f.keyBoxes))
+	pubKeys := make([]*PublicKey, 0, len(f.keyBoxes))
 	for i := range f.keyBoxes {
-		pubKeys[i] = f.keyBoxes[i].PublicKey
+		if f.keyBoxes[i].PublicKey != nil {
+			pubKeys = append(pubKeys, f.keyBoxes[i].PublicKey)
+		}
 	}
 	return pubKeys
 }
 
-// RemovePublicKey removes the given public key from the EncFile.
+// KeyBoxes returns the EncFile's key boxes. Anonymous key boxes' public keys
+// are only known once the EncFile is unsealed.
+func (f *EncFile) KeyBoxes() []*KeyBox {
+	return append([]*KeyBox(nil), f.keyBoxes...)
+}
+
+// HasKeyBoxOptions returns true if any key box has an expiry or metadata.
+// These are authenticated by the HeaderMAC, so removing such a key box
+// requires unsealing the EncFile.
+func (f *EncFile) HasKeyBoxOptions() bool {
+	for _, keyBox := range f.keyBoxes {
+		if keyBox.marshalOptions() != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// AnonymousKeyBoxes returns the number of anonymous key boxes in this EncFile.
+func (f *EncFile) AnonymousKeyBoxes() int {
+	var n int
+	for _, keyBox := range f.keyBoxes {
+		if keyBox.anonymous {
+			n++
+		}
+	}
+	return n
+}
+
+// RemovePublicKey removes the given public key from the EncFile. A share
+// holder can't be removed if fewer shares than the threshold would remain.
 func (f *EncFile) RemovePublicKey(pubKey *PublicKey) error {
-	updated := f.keyBoxes[:0]
+	updated := make([]*KeyBox, 0, len(f.keyBoxes))
 	var removed bool
 	for _, keyBox := range f.keyBoxes {
 		if keyBox.PublicKey != pubKey {
@@ -71,33 +207,118 @@ func (f *EncFile) RemovePublicKey(pubKey *PublicKey) error {
 	if !removed {
 		return ErrPublicKeyNotFound
 	}
+	if threshold := f.threshold(); threshold > 0 {
+		var shares int
+		for _, keyBox := range updated {
+			if keyBox.threshold > 0 {
+				shares++
+			}
+		}
+		if shares < threshold {
+			return fmt.Errorf("%w (%d left, %d needed)", ErrTooFewShares, shares, threshold)
+		}
+	}
 	f.keyBoxes = updated
 	return nil
 }
 
-// Unseal the EncFile with the given PrivateKey.
-func (f *EncFile) Unseal(privKey *PrivateKey) (*UnsealedEncFile, error) {
-	keyBox := f.getKeyBox(privKey.publicKey())
-	if keyBox == nil {
-		return nil, fmt.Errorf("no key box found for key labeled %q", privKey.Label)
+// Unseal the EncFile with the first of the given Identities that has a key box.
+//
+// Anonymous key boxes are tried with each Identity in turn. There is no hint
+// to say which box belongs to which Identity, since any hint that doesn't
+// reveal the recipient would cost as much to check as opening the box.
+func (f *EncFile) Unseal(identities ...Identity) (*UnsealedEncFile, error) {
+	var openErr error
+	tried := make([]*PublicKey, len(identities))
+	for i, identity := range identities {
+		tried[i] = identity.Recipient()
+		keyBox := f.getKeyBox(tried[i])
+		if keyBox == nil || keyBox.threshold > 0 {
+			continue
+		}
+		fileKey, err := identity.OpenKeyBox(keyBox)
+		if err != nil {
+			openErr = err
+			continue
+		}
+		return f.unsealWith(fileKey)
 	}
-	var fileKey [32]byte
-	out, ok := box.OpenAnonymous(fileKey[:0], keyBox.box, keyBox.PublicKey.key, privKey.key)
-	if !ok || len(out) != len(fileKey) {
-		return nil, fmt.Errorf("unboxing key failed with private key %q", privKey.Label)
+	for _, keyBox := range f.keyBoxes {
+		if !keyBox.anonymous || keyBox.PublicKey != nil {
+			continue
+		}
+		for i, identity := range identities {
+			fileKey, err := identity.OpenKeyBox(&KeyBox{box: keyBox.box, PublicKey: tried[i]})
+			if errors.Is(err, ErrAgentLocked) {
+				openErr = err
+			} else if err == nil {
+				return f.unsealWith(fileKey)
+			}
+		}
+	}
+
+	// The identities may hold enough shares between them
+	shares, err := f.OpenShares(identities...)
+	if err != nil && !errors.Is(err, ErrKeyBoxNotFound) {
+		openErr = err
+	} else if threshold := f.threshold(); threshold > 0 && len(shares) >= threshold {
+		return f.UnsealShares(shares...)
+	}
+
+	if openErr != nil {
+		return nil, openErr
+	}
+	return nil, &NoKeyBoxError{
+		Recipients: f.PublicKeys(),
+		Tried:      tried,
+		Anonymous:  f.AnonymousKeyBoxes(),
+		Shares:     len(shares),
+		Threshold:  f.threshold(),
 	}
-	return &UnsealedEncFile{EncFile: f, fileKey: &fileKey}, nil
 }
 
-// FileSize returns the plaintext file size.
+func (f *EncFile) unsealWith(fileKey *[32]byte) (*UnsealedEncFile, error) {
+	if err := f.verifyHeaders(fileKey); err != nil {
+		return nil, err
+	}
+	for _, keyBox := range f.keyBoxes {
+		if keyBox.PublicKey == nil {
+			return nil, errors.New("anonymous key box without recipient metadata")
+		}
+	}
+	unsealed := &UnsealedEncFile{EncFile: f, fileKey: fileKey, rand: rand.Reader}
+	if f.bundle {
+		if err := unsealed.verifyBundle(); err != nil {
+			return nil, err
+		}
+	}
+	return unsealed, nil
+}
+
+// FileSize returns the stored plaintext size, after any compression and
+// padding.
 func (f *EncFile) FileSize() int {
 	cipherSize := len(f.ciphertext)
-	chunks := (cipherSize / cipherChunkSize) + 1
-	return cipherSize - (secretbox.Overhead * chunks)
+	return cipherSize - (secretbox.Overhead * f.chunkCount())
+}
+
+// OriginalSize returns the plaintext size before any compression or padding,
+// or -1 if it isn't known without decrypting.
+func (f *EncFile) OriginalSize() int64 {
+	if f.Compression != "" {
+		return f.originalSize
+	}
+	if f.Padding != "" {
+		return -1
+	}
+	return int64(f.FileSize())
 }
 
 func (f *EncFile) getKeyBox(pubKey *PublicKey) *KeyBox {
 	for i := range f.keyBoxes {
+		if f.keyBoxes[i].PublicKey == nil {
+			continue
+		}
 		pubKeyBytes := f.keyBoxes[i].PublicKey.key
 		if *pubKeyBytes == *pubKey.key {
 			return f.keyBoxes[i]
@@ -106,147 +327,362 @@ func (f *EncFile) getKeyBox(pubKey *PublicKey) *KeyBox {
 	return nil
 }
 
-// WriteTo writes the EncFile to the given Writer.
+// WriteTo writes the EncFile to the given Writer, as PEM armored text or in
+// the binary encoding if Binary is set.
 func (f *EncFile) WriteTo(w io.Writer) (n int64, err error) {
+	if f.Binary {
+		return f.writeBinary(w)
+	}
+	// Encode everything first, so nothing is written if encoding fails
+	var buf bytes.Buffer
 	for i := range f.keyBoxes {
-		lineN, err := fmt.Fprintln(w, f.keyBoxes[i].MarshalString())
-		n += int64(lineN)
-		if err != nil {
-			return n, err
+		fmt.Fprintln(&buf, f.keyBoxes[i].MarshalString())
+	}
+	for _, block := range f.blocks() {
+		if err := pem.Encode(&buf, block); err != nil {
+			return 0, fmt.Errorf("encoding %s block: %w", block.Type, err)
 		}
 	}
-	headers := map[string]string{}
-	if f.Filename != "" {
-		headers["Filename"] = f.Filename
+	return buf.WriteTo(w)
+}
+
+// blocks returns the EncFile's block followed by any bundle entries' blocks.
+func (f *EncFile) blocks() []*pem.Block {
+	blockType := encryptedFileBlockType
+	if f.bundle {
+		blockType = bundleBlockType
 	}
-	if len(f.MAC) > 0 {
-		headers["MAC"] = hex.EncodeToString(f.MAC)
+	blocks := []*pem.Block{f.block(blockType)}
+	for _, entry := range f.entries {
+		blocks = append(blocks, entry.block(bundleEntryBlockType))
 	}
-	if len(f.nonce) > 0 {
-		headers["Nonce"] = hex.EncodeToString(f.nonce)
+	return blocks
+}
+
+func (f *EncFile) block(blockType string) *pem.Block {
+	headers := f.headers()
+	if len(f.headerMAC) > 0 {
+		headers[headerHeaderMAC] = hex.EncodeToString(f.headerMAC)
 	}
-	blockBytes := pem.EncodeToMemory(&pem.Block{
-		Type:    encryptedFileBlockType,
+	return &pem.Block{
+		Type:    blockType,
 		Headers: headers,
 		Bytes:   f.ciphertext,
-	})
-	blockN, err := w.Write(blockBytes)
-	n += int64(blockN)
-	return n, err
+	}
 }
 
-// ReadFrom reads an EncFile from a Reader.
+// ReadFrom reads an EncFile from a Reader, in either encoding.
 func (f *EncFile) ReadFrom(r io.Reader) (n int64, err error) {
 	f.keyBoxes = nil
-	br := bufio.NewReader(r)
+	br := bufio.NewReaderSize(io.LimitReader(r, maxEncFileSize+1), maxLineLength)
+	if magic, _ := br.Peek(len(binaryMagic)); string(magic) == binaryMagic {
+		f.Binary = true
+		return f.readBinary(br)
+	}
+	f.Binary = false
+
 	lineNum := 0
 	for {
-		line, err := br.ReadString('\n')
-		n += int64(len(line))
 		lineNum++
-		if err != nil {
+		if nextByte, err := br.Peek(1); err == io.EOF {
+			return n, &ParseError{Line: lineNum, Err: errMissingBlock}
+		} else if err != nil {
 			return n, err
+		} else if nextByte[0] == '-' {
+			break
 		}
 
-		keyBox := &KeyBox{}
-		if err := keyBox.UnmarshalString(line); err != nil {
-			return n, fmt.Errorf("%w (at line %d)", err, lineNum)
+		if len(f.keyBoxes) >= maxKeyBoxes {
+			return n, &ParseError{Line: lineNum, Err: errTooManyKeyBoxes}
 		}
-		f.keyBoxes = append(f.keyBoxes, keyBox)
 
-		if nextByte, err := br.Peek(1); err != nil {
+		line, err := br.ReadSlice('\n')
+		n += int64(len(line))
+		if err == bufio.ErrBufferFull {
+			return n, &ParseError{Line: lineNum, Column: maxLineLength + 1, Err: errLineTooLong}
+		} else if err == io.EOF {
+			return n, &ParseError{Line: lineNum + 1, Err: errMissingBlock}
+		} else if err != nil {
 			return n, err
-		} else if nextByte[0] == '-' {
-			break
 		}
+
+		keyBox := &KeyBox{}
+		if err := keyBox.UnmarshalString(string(line)); err != nil {
+			return n, atLine(err, lineNum)
+		}
+		f.keyBoxes = append(f.keyBoxes, keyBox)
 	}
 
-	rest, err := ioutil.ReadAll(br)
-	n += int64(len(rest))
+	data, err := ioutil.ReadAll(br)
+	n += int64(len(data))
 	if err != nil {
 		return n, err
 	}
-	block, rest := pem.Decode(rest)
-	if block == nil || len(bytes.TrimSpace(rest)) > 0 {
-		return n, errBadEncFileEncoding
+	if n > maxEncFileSize {
+		return n, errEncFileTooLarge
 	}
-	if block.Type != encryptedFileBlockType {
-		return n, fmt.Errorf("unknown block type %q", block.Type)
+	var blocks []*pem.Block
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		blocks = append(blocks, block)
 	}
+	if len(bytes.TrimSpace(rest)) > 0 {
+		trailing := len(data) - len(bytes.TrimLeft(rest, " \t\r\n"))
+		return n, &ParseError{Line: lineNum + bytes.Count(data[:trailing], []byte("\n")), Err: errBadEncFileEncoding}
+	}
+	return n, f.parseBlocks(blocks)
+}
 
-	f.Filename = block.Headers["Filename"]
-
-	f.MAC, err = hex.DecodeString(block.Headers["MAC"])
-	if err != nil {
-		return n, fmt.Errorf("decoding MAC: %w", err)
+// parseBlocks sets the EncFile from its block and any bundle entries' blocks.
+func (f *EncFile) parseBlocks(blocks []*pem.Block) error {
+	if len(blocks) == 0 {
+		return errBadEncFileEncoding
+	}
+	switch blocks[0].Type {
+	case encryptedFileBlockType:
+		if len(blocks) > 1 {
+			return errBadEncFileEncoding
+		}
+		f.bundle = false
+	case bundleBlockType:
+		f.bundle = true
+	default:
+		return fmt.Errorf("unknown block type %q", blocks[0].Type)
+	}
+	if err := f.parseBlock(blocks[0]); err != nil {
+		return err
 	}
 
-	f.nonce, err = hex.DecodeString(block.Headers["Nonce"])
-	if err != nil {
-		return n, fmt.Errorf("decoding Nonce: %w", err)
+	f.entries = nil
+	for _, block := range blocks[1:] {
+		if block.Type != bundleEntryBlockType {
+			return fmt.Errorf("unknown block type %q", block.Type)
+		}
+		entry := &EncFile{}
+		if err := entry.parseBlock(block); err != nil {
+			return fmt.Errorf("bundle entry %d: %w", len(f.entries)+1, err)
+		}
+		f.entries = append(f.entries, entry)
 	}
+	return nil
+}
 
+func (f *EncFile) parseBlock(block *pem.Block) error {
+	if err := f.parseHeaders(block.Headers); err != nil {
+		return err
+	}
 	f.ciphertext = block.Bytes
-
-	return n, nil
+	return nil
 }
 
 // UnsealedEncFile is an unsealed EncFile.
 type UnsealedEncFile struct {
 	*EncFile
 	fileKey *[32]byte
+
+	// rand is the source of keys and nonces: crypto/rand, except when
+	// generating test vectors.
+	rand io.Reader
 }
 
 // NewUnsealedEncFile encrypts the given content and returns a new UnsealedEncFile.
 func NewUnsealedEncFile(filename string) (*UnsealedEncFile, error) {
+	return newUnsealedEncFile(filename, rand.Reader)
+}
+
+func newUnsealedEncFile(filename string, rand io.Reader) (*UnsealedEncFile, error) {
 	var fileKey [32]byte
-	if _, err := rand.Read(fileKey[:]); err != nil {
+	if _, err := io.ReadFull(rand, fileKey[:]); err != nil {
 		return nil, err
 	}
 	return &UnsealedEncFile{
-		EncFile: &EncFile{Filename: filename},
+		EncFile: &EncFile{Filename: filename, originalSize: -1},
 		fileKey: &fileKey,
+		rand:    rand,
 	}, nil
 }
 
+// WriteTo authenticates the headers with the file key and writes the EncFile
+// to the given Writer.
+func (f *UnsealedEncFile) WriteTo(w io.Writer) (n int64, err error) {
+	sortKeyBoxes(f.keyBoxes)
+	if err := f.authenticateHeaders(f.fileKey, f.rand); err != nil {
+		return 0, err
+	}
+	for _, entry := range f.entries {
+		entry.HideMetadata = f.HideMetadata
+		entry.Deterministic = f.Deterministic
+		if err := entry.authenticateHeaders(f.fileKey, f.rand); err != nil {
+			return 0, err
+		}
+	}
+	return f.EncFile.WriteTo(w)
+}
+
 // AddPublicKey adds the given PublicKey to the EncFile.
 func (f *UnsealedEncFile) AddPublicKey(pubKey *PublicKey) error {
+	return f.addPublicKey(pubKey, false)
+}
+
+// AddAnonymousPublicKey adds the given PublicKey to the EncFile in an
+// anonymous key box. The PublicKey is stored in the encrypted metadata, so
+// this also sets HideMetadata.
+func (f *UnsealedEncFile) AddAnonymousPublicKey(pubKey *PublicKey) error {
+	return f.addPublicKey(pubKey, true)
+}
+
+func (f *UnsealedEncFile) addPublicKey(pubKey *PublicKey, anonymous bool) error {
 	if f.getKeyBox(pubKey) != nil {
 		return ErrAlreadyAdded
 	}
+	if pubKey.Expired(timeNow()) {
+		return ErrKeyExpired
+	}
 	keyBox, err := f.sealKeyBox(pubKey)
 	if err != nil {
 		return err
 	}
+	keyBox.anonymous = anonymous
+	if anonymous {
+		f.HideMetadata = true
+	}
 	f.keyBoxes = append(f.keyBoxes, keyBox)
 	return nil
 }
 
+// SetKeyBoxMetadata sets the metadata of the given PublicKey's key box.
+func (f *UnsealedEncFile) SetKeyBoxMetadata(pubKey *PublicKey, metadata KeyBoxMetadata) error {
+	keyBox := f.getKeyBox(pubKey)
+	if keyBox == nil {
+		return ErrPublicKeyNotFound
+	}
+	if keyBox.anonymous {
+		return errAnonymousMetadata
+	}
+	if strings.ContainsAny(metadata.AddedBy+metadata.Comment, "\r\n") {
+		return errMetadataNewline
+	}
+	keyBox.KeyBoxMetadata = metadata
+	return nil
+}
+
+// AddThresholdPublicKeys splits the file key into shares, one sealed to each
+// of the given PublicKeys, so that any threshold of them can recover it
+// together. An EncFile may have one set of threshold recipients.
+func (f *UnsealedEncFile) AddThresholdPublicKeys(threshold int, pubKeys ...*PublicKey) error {
+	if f.threshold() > 0 {
+		return errThresholdExists
+	}
+	for i, pubKey := range pubKeys {
+		if f.getKeyBox(pubKey) != nil {
+			return ErrAlreadyAdded
+		}
+		for _, other := range pubKeys[:i] {
+			if *other.key == *pubKey.key {
+				return ErrAlreadyAdded
+			}
+		}
+		if pubKey.Expired(timeNow()) {
+			return ErrKeyExpired
+		}
+	}
+	keyBoxes, err := f.sealShares(threshold, pubKeys)
+	if err != nil {
+		return err
+	}
+	f.keyBoxes = append(f.keyBoxes, keyBoxes...)
+	return nil
+}
+
+// sealShares splits the file key and seals the shares to pubKeys.
+func (f *UnsealedEncFile) sealShares(threshold int, pubKeys []*PublicKey) ([]*KeyBox, error) {
+	shares, err := splitSecret(f.fileKey[:], len(pubKeys), threshold, f.rand)
+	if err != nil {
+		return nil, err
+	}
+	keyBoxes := make([]*KeyBox, len(pubKeys))
+	for i, pubKey := range pubKeys {
+		boxedShare, err := box.SealAnonymous(nil, shares[i], pubKey.key, f.rand)
+		if err != nil {
+			return nil, fmt.Errorf("sealing key share: %w", err)
+		}
+		keyBoxes[i] = &KeyBox{
+			box:        boxedShare,
+			PublicKey:  pubKey,
+			shareIndex: byte(i + 1),
+			threshold:  threshold,
+		}
+	}
+	return keyBoxes, nil
+}
+
 // RotateFileKey generates a new file key and rebuilds the UnsealedEncFile with it.
 func (f *UnsealedEncFile) RotateFileKey() error {
 	// Decrypt
-	plaintext, err := f.Decrypt()
+	plaintext, err := f.decrypt()
 	if err != nil {
 		return fmt.Errorf("decrypting message: %w", err)
 	}
+	entryPlaintexts := make([][]byte, len(f.entries))
+	for i, entry := range f.entries {
+		entryPlaintexts[i], err = f.entry(entry).decrypt()
+		if err != nil {
+			return fmt.Errorf("decrypting bundle entry %q: %w", entry.Filename, err)
+		}
+	}
 
 	// Regenerate file key
-	if _, err := rand.Read(f.fileKey[:]); err != nil {
+	if _, err := io.ReadFull(f.rand, f.fileKey[:]); err != nil {
 		return fmt.Errorf("regenerating file key: %w", err)
 	}
 
-	// Regenerate key boxes
-	var newKeyBoxes []*KeyBox
+	// Regenerate key boxes, splitting the new key between any share holders
+	var newKeyBoxes, shareKeyBoxes []*KeyBox
+	var shareKeys []*PublicKey
+	for _, keyBox := range f.keyBoxes {
+		if keyBox.threshold > 0 {
+			shareKeyBoxes = append(shareKeyBoxes, keyBox)
+			shareKeys = append(shareKeys, keyBox.PublicKey)
+		}
+	}
+	if len(shareKeys) > 0 {
+		newShareKeyBoxes, err := f.sealShares(f.threshold(), shareKeys)
+		if err != nil {
+			return fmt.Errorf("regenerating key shares: %w", err)
+		}
+		for i, keyBox := range newShareKeyBoxes {
+			keyBox.KeyBoxMetadata = shareKeyBoxes[i].KeyBoxMetadata
+		}
+		newKeyBoxes = newShareKeyBoxes
+	}
 	for _, keyBox := range f.keyBoxes {
+		if keyBox.threshold > 0 {
+			continue
+		}
 		newKeyBox, err := f.sealKeyBox(keyBox.PublicKey)
 		if err != nil {
 			return fmt.Errorf("regenerating key box %q: %w", keyBox.Label, err)
 		}
+		newKeyBox.anonymous = keyBox.anonymous
+		newKeyBox.KeyBoxMetadata = keyBox.KeyBoxMetadata
 		newKeyBoxes = append(newKeyBoxes, newKeyBox)
 	}
 
 	// Re-encrypt plaintext
-	if err := f.Encrypt(plaintext); err != nil {
+	for i, entry := range f.entries {
+		if err := f.entry(entry).encrypt(entryPlaintexts[i]); err != nil {
+			return fmt.Errorf("re-encrypting bundle entry %q: %w", entry.Filename, err)
+		}
+	}
+	if f.bundle {
+		plaintext = f.bundleIndex()
+	}
+	if err := f.encrypt(plaintext); err != nil {
 		return fmt.Errorf("re-encrypting plaintext: %w", err)
 	}
 
@@ -255,7 +691,7 @@ func (f *UnsealedEncFile) RotateFileKey() error {
 }
 
 func (f *UnsealedEncFile) sealKeyBox(pubKey *PublicKey) (*KeyBox, error) {
-	boxedKey, err := box.SealAnonymous(nil, f.fileKey[:], pubKey.key, rand.Reader)
+	boxedKey, err := box.SealAnonymous(nil, f.fileKey[:], pubKey.key, f.rand)
 	if err != nil {
 		return nil, fmt.Errorf("sealing file key: %w", err)
 	}
@@ -266,85 +702,138 @@ func (f *UnsealedEncFile) sealKeyBox(pubKey *PublicKey) (*KeyBox, error) {
 
 }
 
-// Encrypt encrypts the file contents.
-func (f *UnsealedEncFile) Encrypt(plaintext []byte) error {
+// PlaintextMAC returns the MAC that Encrypt records for the given plaintext.
+func (f *UnsealedEncFile) PlaintextMAC(plaintext []byte) []byte {
 	mac := hmac.New(sha256.New, f.fileKey[:])
+	mac.Write(plaintext)
+	return mac.Sum(nil)
+}
 
-	// Generate a random nonce
-	var nonce [24]byte
-	if _, err := rand.Read(nonce[:]); err != nil {
-		return fmt.Errorf("generating nonce: %w", err)
+// Encrypt encrypts the file contents.
+func (f *UnsealedEncFile) Encrypt(plaintext []byte) error {
+	if f.bundle {
+		return ErrBundle
 	}
+	return f.encrypt(plaintext)
+}
 
-	// Initialize counter from nonce
-	counter := binary.LittleEndian.Uint64(nonce[:])
-	var counterBytes [24]byte
-	copy(counterBytes[:], nonce[:])
-
-	var out []byte
-	for len(plaintext) > 0 {
-		// Get the next chunkSize-sized chunk
-		chunk := plaintext
-		if len(chunk) > chunkSize {
-			chunk = chunk[:chunkSize]
-			plaintext = plaintext[chunkSize:]
-		} else {
-			plaintext = nil
-		}
+func (f *UnsealedEncFile) encrypt(plaintext []byte) error {
+	// Compute the MAC alongside encryption
+	macSum := make(chan []byte, 1)
+	go func(plaintext []byte) {
+		macSum <- f.PlaintextMAC(plaintext)
+	}(plaintext)
+
+	// Don't record the original size if padding is meant to hide it
+	originalSize := int64(-1)
+	if f.Padding == "" || f.HideMetadata {
+		originalSize = int64(len(plaintext))
+	}
 
-		// Update MAC
-		mac.Write(chunk)
+	plaintext, err := compress(plaintext, f.Compression)
+	if err != nil {
+		return fmt.Errorf("compressing: %w", err)
+	}
 
-		// Update counter
-		binary.LittleEndian.PutUint64(counterBytes[:], counter)
-		counter++
+	plaintext, err = pad(plaintext, f.Padding)
+	if err != nil {
+		return err
+	}
 
-		// Encrypt the chunk
-		out = secretbox.Seal(out, chunk, &counterBytes, f.fileKey)
+	// Generate a random nonce, or derive one from the sealed plaintext
+	var nonce [24]byte
+	if f.Deterministic {
+		nonce = syntheticNonce(f.fileKey, nonceKeyInfo, plaintext)
+	} else if _, err := io.ReadFull(f.rand, nonce[:]); err != nil {
+		return fmt.Errorf("generating nonce: %w", err)
 	}
 
-	f.MAC = mac.Sum(nil)
+	// Seal chunks in parallel, each into its place in the output
+	chunks := (len(plaintext) + chunkSize - 1) / chunkSize
+	out := make([]byte, len(plaintext)+chunks*secretbox.Overhead)
+	forEachChunk(chunks, func(i int) error {
+		start := i * chunkSize
+		end := start + chunkSize
+		if end > len(plaintext) {
+			end = len(plaintext)
+		}
+		cipherStart := i * cipherChunkSize
+		secretbox.Seal(out[cipherStart:cipherStart], plaintext[start:end], chunkNonce(nonce[:], i), f.fileKey)
+		return nil
+	})
+
+	f.MAC = <-macSum
 	f.nonce = nonce[:]
 	f.ciphertext = out
+	f.originalSize = originalSize
 	return nil
 }
 
 // Decrypt decrypts the file contents.
 func (f *UnsealedEncFile) Decrypt() ([]byte, error) {
-	ciphertext := f.ciphertext
+	if f.bundle {
+		return nil, ErrBundle
+	}
+	return f.decrypt()
+}
 
-	// Initialize counter from nonce
-	var counter uint64
-	if len(f.nonce) > 0 {
-		counter = binary.LittleEndian.Uint64(f.nonce[:])
+func (f *UnsealedEncFile) decrypt() ([]byte, error) {
+	// Open chunks in parallel, each into its place in the output
+	size := f.FileSize()
+	if lastChunk := len(f.ciphertext) % cipherChunkSize; size < 0 || (lastChunk > 0 && lastChunk < secretbox.Overhead) {
+		return nil, fmt.Errorf("decrypt failed")
+	}
+	out := make([]byte, size)
+	err := forEachChunk(f.chunkCount(), func(i int) error {
+		start := i * chunkSize
+		_, err := f.openChunk(out[start:start], i)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	var counterBytes [24]byte
-	copy(counterBytes[:], f.nonce[:])
-
-	var out []byte
-	for len(ciphertext) > 0 {
-		// Get the next chunkSize+overhead-sized chunk
-		chunk := ciphertext
-		if len(chunk) > cipherChunkSize {
-			chunk = chunk[:cipherChunkSize]
-			ciphertext = ciphertext[cipherChunkSize:]
-		} else {
-			ciphertext = nil
-		}
 
-		// Update counter
-		binary.LittleEndian.PutUint64(counterBytes[:], counter)
-		counter++
+	out, err = unpad(out, f.Padding)
+	if err != nil {
+		return nil, err
+	}
+	return decompress(out, f.Compression, f.originalSize)
+}
 
-		var ok bool
-		out, ok = secretbox.Open(out, chunk, &counterBytes, f.fileKey)
-		if !ok {
-			return nil, fmt.Errorf("decrypt failed")
-		}
+// chunkCount returns the number of ciphertext chunks.
+func (f *EncFile) chunkCount() int {
+	return (len(f.ciphertext) + cipherChunkSize - 1) / cipherChunkSize
+}
+
+// openChunk decrypts the i'th chunk, appending it to out.
+func (f *UnsealedEncFile) openChunk(out []byte, i int) ([]byte, error) {
+	// Get the i'th chunkSize+overhead-sized chunk
+	start := i * cipherChunkSize
+	end := start + cipherChunkSize
+	if end > len(f.ciphertext) {
+		end = len(f.ciphertext)
+	}
+
+	out, ok := secretbox.Open(out, f.ciphertext[start:end], chunkNonce(f.nonce, i), f.fileKey)
+	if !ok {
+		return nil, fmt.Errorf("decrypt failed")
 	}
 	return out, nil
 }
 
+// chunkNonce returns the i'th chunk's nonce: the file nonce with its first 8
+// bytes, as a little-endian counter, incremented by i.
+func chunkNonce(nonce []byte, i int) *[24]byte {
+	var counter uint64
+	if len(nonce) > 0 {
+		counter = binary.LittleEndian.Uint64(nonce)
+	}
+	var counterBytes [24]byte
+	copy(counterBytes[:], nonce)
+	binary.LittleEndian.PutUint64(counterBytes[:], counter+uint64(i))
+	return &counterBytes
+}
+
 // GoString doesn't print the key bytes.
 func (f *UnsealedEncFile) GoString() string {
 	return fmt.Sprintf("UnsealedEncFile{EncFile: %#v}", f.EncFile)
@@ -2,7 +2,10 @@ package internal
 
 import (
 	"bytes"
+	"errors"
 	"math/rand"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -27,6 +30,24 @@ func TestEncFile_RoundTrip(t *testing.T) {
 	assert.Equal(t, []byte("testData"), plaintext)
 }
 
+func TestEncFile_UnsealIdentities(t *testing.T) {
+	unsealedFile, _, privKey := generateTestUnsealedEncFile(t)
+
+	_, otherPrivKey, err := GenerateKeys("otherLabel")
+	assert.NoError(t, err)
+
+	_, err = unsealedFile.EncFile.Unseal(otherPrivKey, privKey)
+	assert.NoError(t, err)
+
+	_, err = unsealedFile.EncFile.Unseal(otherPrivKey)
+	assert.True(t, errors.Is(err, ErrKeyBoxNotFound))
+	var noKeyBoxErr *NoKeyBoxError
+	if assert.True(t, errors.As(err, &noKeyBoxErr)) {
+		assert.Equal(t, "otherLabel", noKeyBoxErr.Tried[0].Label)
+		assert.Equal(t, "testLabel", noKeyBoxErr.Recipients[0].Label)
+	}
+}
+
 func TestEncFile_PublicKeys(t *testing.T) {
 	unsealedFile, pubKey, _ := generateTestUnsealedEncFile(t)
 	pubKeys := unsealedFile.PublicKeys()
@@ -68,6 +89,12 @@ func TestUnsealedEncFile_LargePlaintext(t *testing.T) {
 	assert.Equal(t, testData, plaintext)
 }
 
+func TestUnsealedEncFile_PlaintextMAC(t *testing.T) {
+	unsealedFile, _, _ := generateTestUnsealedEncFile(t)
+	assert.Equal(t, unsealedFile.MAC, unsealedFile.PlaintextMAC([]byte("testData")))
+	assert.NotEqual(t, unsealedFile.MAC, unsealedFile.PlaintextMAC([]byte("otherData")))
+}
+
 func TestUnsealedEncFile_RotateFileKey(t *testing.T) {
 	unsealedFile, pubKey, _ := generateTestUnsealedEncFile(t)
 
@@ -103,3 +130,168 @@ func generateTestUnsealedEncFile(t *testing.T) (*UnsealedEncFile, *PublicKey, *P
 
 	return unsealedFile, pubKey, privKey
 }
+
+func TestEncFile_UnsealAnonymous(t *testing.T) {
+	unsealedFile, pubKey, privKey := generateTestUnsealedEncFile(t)
+	anonPubKey, anonPrivKey, err := GenerateKeys("anonLabel")
+	assert.NoError(t, err)
+	assert.NoError(t, unsealedFile.AddAnonymousPublicKey(anonPubKey))
+	assert.True(t, unsealedFile.HideMetadata)
+
+	buf := &bytes.Buffer{}
+	_, err = unsealedFile.WriteTo(buf)
+	assert.NoError(t, err)
+	assert.NotContains(t, buf.String(), "anonLabel")
+	assert.NotContains(t, buf.String(), anonPubKey.KeyBase64())
+
+	encFile := &EncFile{}
+	_, err = encFile.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []*PublicKey{pubKey}, encFile.PublicKeys())
+	assert.Equal(t, 1, encFile.AnonymousKeyBoxes())
+
+	_, otherPrivKey, err := GenerateKeys("otherLabel")
+	assert.NoError(t, err)
+	_, err = encFile.Unseal(otherPrivKey)
+	assert.True(t, errors.Is(err, ErrKeyBoxNotFound))
+
+	unsealed, err := encFile.Unseal(anonPrivKey)
+	assert.NoError(t, err)
+	assert.Len(t, unsealed.PublicKeys(), 2)
+	assert.Equal(t, "anonLabel", unsealed.PublicKeys()[1].Label)
+
+	_, err = encFile.Unseal(privKey)
+	assert.NoError(t, err)
+}
+
+func TestEncFile_ReadFromParseErrors(t *testing.T) {
+	unsealedFile, _, _ := generateTestUnsealedEncFile(t)
+	buf := &bytes.Buffer{}
+	_, err := unsealedFile.WriteTo(buf)
+	assert.NoError(t, err)
+	keyBoxLine := strings.SplitAfter(buf.String(), "\n")[0]
+	block := buf.String()[len(keyBoxLine):]
+
+	for _, tc := range []struct {
+		name   string
+		data   string
+		line   int
+		column int
+		err    error
+	}{
+		{"empty", "", 1, 0, errMissingBlock},
+		{"no block", keyBoxLine, 2, 0, errMissingBlock},
+		{"no newline", strings.TrimSpace(keyBoxLine), 2, 0, errMissingBlock},
+		{"line too long", strings.Repeat("x", maxLineLength+1) + "\n" + block, 1, maxLineLength + 1, errLineTooLong},
+		{"bad type", keyBoxLine + "devcrypt-box x\n" + block, 2, 1, nil},
+		{"bad base64", "devcrypt-keybox AA*A " + testKeyBase64 + " label\n" + block, 1, 19, nil},
+		{"trailing", keyBoxLine + block + "\ntrailing\n", 11, 0, errBadEncFileEncoding},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := (&EncFile{}).ReadFrom(strings.NewReader(tc.data))
+			var parseErr *ParseError
+			if assert.True(t, errors.As(err, &parseErr), "%v", err) {
+				assert.Equal(t, tc.line, parseErr.Line, "%v", err)
+				assert.Equal(t, tc.column, parseErr.Column, "%v", err)
+			}
+			if tc.err != nil {
+				assert.True(t, errors.Is(err, tc.err), "%v", err)
+			}
+		})
+	}
+}
+
+func TestEncFile_ReadFromNoKeyBoxes(t *testing.T) {
+	unsealedFile, _, privKey := generateTestUnsealedEncFile(t)
+	buf := &bytes.Buffer{}
+	_, err := unsealedFile.WriteTo(buf)
+	assert.NoError(t, err)
+	data := buf.String()
+
+	encFile := &EncFile{}
+	_, err = encFile.ReadFrom(strings.NewReader(data[strings.Index(data, "-----BEGIN"):]))
+	assert.NoError(t, err)
+	assert.Empty(t, encFile.PublicKeys())
+
+	_, err = encFile.Unseal(privKey)
+	assert.True(t, errors.Is(err, ErrKeyBoxNotFound), "%v", err)
+}
+
+func TestEncFile_KeyBoxOrder(t *testing.T) {
+	unsealedFile, pubKey, _ := generateTestUnsealedEncFile(t)
+	var pubKeys []*PublicKey
+	for i := 0; i < 5; i++ {
+		otherPubKey, _, err := GenerateKeys("otherLabel")
+		assert.NoError(t, err)
+		assert.NoError(t, unsealedFile.AddPublicKey(otherPubKey))
+		pubKeys = append(pubKeys, otherPubKey)
+	}
+	anonPubKey, _, err := GenerateKeys("anonLabel")
+	assert.NoError(t, err)
+	assert.NoError(t, unsealedFile.AddAnonymousPublicKey(anonPubKey))
+
+	_, err = unsealedFile.WriteTo(&bytes.Buffer{})
+	assert.NoError(t, err)
+
+	fingerprints := []string{pubKey.Fingerprint()}
+	for _, pubKey := range pubKeys {
+		fingerprints = append(fingerprints, pubKey.Fingerprint())
+	}
+	sort.Strings(fingerprints)
+	var written []string
+	for _, keyBox := range unsealedFile.keyBoxes[:len(fingerprints)] {
+		written = append(written, keyBox.Fingerprint())
+	}
+	assert.Equal(t, fingerprints, written)
+	assert.True(t, unsealedFile.keyBoxes[len(fingerprints)].Anonymous())
+}
+
+func TestEncFile_Deterministic(t *testing.T) {
+	unsealedFile, _, privKey := generateTestUnsealedEncFile(t)
+	unsealedFile.Deterministic = true
+	unsealedFile.HideMetadata = true
+	assert.NoError(t, unsealedFile.Encrypt([]byte("testData")))
+
+	before := &bytes.Buffer{}
+	_, err := unsealedFile.WriteTo(before)
+	assert.NoError(t, err)
+
+	// Re-encrypting the same plaintext reproduces the file
+	assert.NoError(t, unsealedFile.Encrypt([]byte("testData")))
+	reencrypted := &bytes.Buffer{}
+	_, err = unsealedFile.WriteTo(reencrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, before.String(), reencrypted.String())
+
+	// Adding a recipient adds only its line
+	otherPubKey, _, err := GenerateKeys("otherLabel")
+	assert.NoError(t, err)
+	assert.NoError(t, unsealedFile.AddPublicKey(otherPubKey))
+	after := &bytes.Buffer{}
+	_, err = unsealedFile.WriteTo(after)
+	assert.NoError(t, err)
+	var added []string
+	for _, line := range strings.SplitAfter(after.String(), "\n") {
+		if !strings.Contains(before.String(), line) {
+			added = append(added, line)
+		}
+	}
+	assert.Equal(t, 1, len(added))
+	assert.Contains(t, added[0], otherPubKey.KeyBase64())
+	assert.Equal(t, len(before.String())+len(added[0]), len(after.String()))
+
+	encFile := &EncFile{}
+	_, err = encFile.ReadFrom(after)
+	assert.NoError(t, err)
+	unsealed, err := encFile.Unseal(privKey)
+	assert.NoError(t, err)
+	assert.True(t, unsealed.Deterministic)
+	plaintext, err := unsealed.Decrypt()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("testData"), plaintext)
+
+	// A different plaintext gets a different nonce
+	nonce := unsealedFile.nonce
+	assert.NoError(t, unsealedFile.Encrypt([]byte("otherData")))
+	assert.NotEqual(t, nonce, unsealedFile.nonce)
+}
@@ -0,0 +1,115 @@
+//go:build go1.18
+// +build go1.18
+
+package internal
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Run a target with e.g. go test -run '^$' -fuzz FuzzEncFileReadFrom
+
+func FuzzKeyBoxUnmarshalString(f *testing.F) {
+	f.Add("devcrypt-keybox " + testBoxBase64 + " " + testKeyBase64 + " testLabel")
+	f.Add("devcrypt-anon-keybox " + testBoxBase64)
+	f.Add(`expires=2030-01-01T00:00:00Z,comment="a \"b\"" devcrypt-keybox ` + testBoxBase64 + " " + testKeyBase64 + " testLabel")
+	f.Add("devcrypt-share-keybox 3 2 " + testBoxBase64 + " " + testKeyBase64 + " testLabel")
+	f.Fuzz(func(t *testing.T, data string) {
+		box := &KeyBox{}
+		if err := box.UnmarshalString(data); err != nil {
+			return
+		}
+		decoded := &KeyBox{}
+		if err := decoded.UnmarshalString(box.MarshalString()); err != nil {
+			t.Fatalf("re-decoding %q: %v", box.MarshalString(), err)
+		}
+		if decoded.MarshalString() != box.MarshalString() {
+			t.Fatalf("round trip changed %q to %q", box.MarshalString(), decoded.MarshalString())
+		}
+	})
+}
+
+func FuzzPublicKeyUnmarshalString(f *testing.F) {
+	f.Add("devcrypt-key " + testKeyBase64 + " testLabel")
+	f.Add("expires=2030-01-01T00:00:00Z devcrypt-key " + testKeyBase64 + " testLabel")
+	f.Fuzz(func(t *testing.T, data string) {
+		key := &PublicKey{}
+		if err := key.UnmarshalString(data); err != nil {
+			return
+		}
+		decoded := &PublicKey{}
+		if err := decoded.UnmarshalString(key.MarshalString()); err != nil {
+			t.Fatalf("re-decoding %q: %v", key.MarshalString(), err)
+		}
+		if decoded.MarshalString() != key.MarshalString() {
+			t.Fatalf("round trip changed %q to %q", key.MarshalString(), decoded.MarshalString())
+		}
+	})
+}
+
+func FuzzPrivateKeyUnmarshal(f *testing.F) {
+	for _, name := range []string{"alice_key", "bob_key"} {
+		data, err := ioutil.ReadFile(filepath.Join(vectorsDir, name))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		key := &PrivateKey{}
+		if err := key.Unmarshal(data); err != nil {
+			return
+		}
+		encoded, err := key.Marshal()
+		if err != nil {
+			// e.g. a label that can't be written back
+			return
+		}
+		decoded := &PrivateKey{}
+		if err := decoded.Unmarshal(encoded); err != nil {
+			t.Fatalf("re-decoding: %v\n%s", err, encoded)
+		}
+		if decoded.Label != key.Label || *decoded.key != *key.key {
+			t.Fatalf("round trip changed %#v to %#v", key, decoded)
+		}
+	})
+}
+
+func FuzzEncFileReadFrom(f *testing.F) {
+	paths, err := filepath.Glob(filepath.Join(vectorsDir, "*.devcrypt"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		encFile := &EncFile{}
+		if _, err := encFile.ReadFrom(bytes.NewReader(data)); err != nil {
+			return
+		}
+		encoded := &bytes.Buffer{}
+		if _, err := encFile.WriteTo(encoded); err != nil {
+			// e.g. a label that can't be written back
+			return
+		}
+		decoded := &EncFile{}
+		if _, err := decoded.ReadFrom(bytes.NewReader(encoded.Bytes())); err != nil {
+			t.Fatalf("re-decoding: %v\n%s", err, encoded.Bytes())
+		}
+		reencoded := &bytes.Buffer{}
+		if _, err := decoded.WriteTo(reencoded); err != nil {
+			t.Fatalf("re-encoding: %v", err)
+		}
+		if !bytes.Equal(encoded.Bytes(), reencoded.Bytes()) {
+			t.Fatalf("round trip changed\n%s\nto\n%s", encoded.Bytes(), reencoded.Bytes())
+		}
+	})
+}
@@ -0,0 +1,208 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var updateVectors = flag.Bool("update", false, "rewrite testdata/vectors from the current code")
+
+const vectorsDir = "testdata/vectors"
+
+// goldenVector is generated by setup on a new file (or bundle) for alice,
+// which is then encrypted with the vector plaintext if it isn't a bundle.
+type goldenVector struct {
+	name     string
+	bundle   bool
+	setup    func(f *UnsealedEncFile, bob *PublicKey) error
+	readOnly bool
+}
+
+// goldenVectors pin every version of the encrypted file format. Each is
+// written with deterministic randomness, so the current code must produce
+// it byte for byte, and must decrypt it with alice's (and for anonymous
+// recipients, bob's) key.
+//
+// Vectors without a setup function predate deterministic generation and
+// are only decrypted. Compressed vectors are only decrypted too, since
+// compressor output may change between library versions.
+var goldenVectors = []goldenVector{
+	// example/moose.devcrypt: Filename, MAC and Nonce headers only
+	{name: "v0-legacy"},
+
+	// Authenticated headers with file metadata
+	{name: "v1-header-mac", setup: func(f *UnsealedEncFile, bob *PublicKey) error {
+		f.Mode = 0640
+		f.ModTime = time.Date(2020, 12, 17, 1, 42, 55, 0, time.UTC)
+		return f.AddPublicKey(bob)
+	}},
+
+	// Plaintext spanning several chunks
+	{name: "v1-multi-chunk", setup: func(f *UnsealedEncFile, bob *PublicKey) error {
+		return nil
+	}},
+
+	{name: "v2-hidden-metadata-padded", setup: func(f *UnsealedEncFile, bob *PublicKey) error {
+		f.HideMetadata = true
+		f.Padding = PaddingPowerOfTwo
+		return nil
+	}},
+	{name: "v2-anonymous", setup: func(f *UnsealedEncFile, bob *PublicKey) error {
+		return f.AddAnonymousPublicKey(bob)
+	}},
+	{name: "v2-gzip", readOnly: true, setup: func(f *UnsealedEncFile, bob *PublicKey) error {
+		f.Compression = CompressionGzip
+		return nil
+	}},
+	{name: "v2-zstd", readOnly: true, setup: func(f *UnsealedEncFile, bob *PublicKey) error {
+		f.Compression = CompressionZstd
+		return nil
+	}},
+	{name: "v2-binary", setup: func(f *UnsealedEncFile, bob *PublicKey) error {
+		f.Binary = true
+		return nil
+	}},
+	{name: "v2-bundle", bundle: true, setup: func(f *UnsealedEncFile, bob *PublicKey) error {
+		moose, err := ioutil.ReadFile(filepath.Join(vectorsDir, "moose"))
+		if err != nil {
+			return err
+		}
+		if err := f.WriteEntry("moose", moose); err != nil {
+			return err
+		}
+		return f.WriteEntry("empty", nil)
+	}},
+	{name: "v2-deterministic", setup: func(f *UnsealedEncFile, bob *PublicKey) error {
+		f.Deterministic = true
+		f.HideMetadata = true
+		return f.AddPublicKey(bob)
+	}},
+}
+
+// deterministicReader returns a stream of SHA-256(seed || counter) blocks.
+type deterministicReader struct {
+	seed    string
+	counter uint64
+	buf     []byte
+}
+
+func (r *deterministicReader) Read(p []byte) (int, error) {
+	for len(r.buf) < len(p) {
+		h := sha256.New()
+		h.Write([]byte(r.seed))
+		binary.Write(h, binary.LittleEndian, r.counter)
+		r.counter++
+		r.buf = h.Sum(r.buf)
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func readVectorKey(t *testing.T, name string) *PrivateKey {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join(vectorsDir, name))
+	assert.NoError(t, err)
+	privKey := &PrivateKey{}
+	assert.NoError(t, privKey.Unmarshal(data))
+	return privKey
+}
+
+func vectorPlaintext(t *testing.T, name string) []byte {
+	t.Helper()
+	moose, err := ioutil.ReadFile(filepath.Join(vectorsDir, "moose"))
+	assert.NoError(t, err)
+	if name == "v1-multi-chunk" {
+		return bytes.Repeat(moose, 3*chunkSize/len(moose))
+	}
+	return moose
+}
+
+// generateVector encrypts a vector with deterministic randomness.
+func generateVector(t *testing.T, vector goldenVector, alice, bob *PrivateKey) []byte {
+	t.Helper()
+	rand := &deterministicReader{seed: vector.name}
+
+	var f *UnsealedEncFile
+	var err error
+	if vector.bundle {
+		f, err = newUnsealedBundle(rand)
+	} else {
+		f, err = newUnsealedEncFile("moose", rand)
+	}
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	assert.NoError(t, f.AddPublicKey(alice.Recipient()))
+	assert.NoError(t, vector.setup(f, bob.Recipient()))
+	if !vector.bundle {
+		assert.NoError(t, f.Encrypt(vectorPlaintext(t, vector.name)))
+	}
+
+	buf := &bytes.Buffer{}
+	_, err = f.WriteTo(buf)
+	assert.NoError(t, err)
+	return buf.Bytes()
+}
+
+func TestGoldenVectors(t *testing.T) {
+	alice := readVectorKey(t, "alice_key")
+	bob := readVectorKey(t, "bob_key")
+
+	for _, vector := range goldenVectors {
+		t.Run(vector.name, func(t *testing.T) {
+			path := filepath.Join(vectorsDir, vector.name+".devcrypt")
+			generates := vector.setup != nil
+
+			if *updateVectors && generates {
+				assert.NoError(t, ioutil.WriteFile(path, generateVector(t, vector, alice, bob), 0644))
+			}
+
+			golden, err := ioutil.ReadFile(path)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			if generates && !vector.readOnly {
+				generated := generateVector(t, vector, alice, bob)
+				assert.Equal(t, string(golden), string(generated), "encoding changed; rerun with -update only if this is intended")
+			}
+
+			identities := []*PrivateKey{alice}
+			if strings.HasSuffix(vector.name, "anonymous") {
+				identities = append(identities, bob)
+			}
+			for _, identity := range identities {
+				encFile := &EncFile{}
+				_, err = encFile.ReadFrom(bytes.NewReader(golden))
+				if !assert.NoError(t, err) {
+					return
+				}
+				unsealed, err := encFile.Unseal(identity)
+				if !assert.NoError(t, err, "unsealing with %s", identity.Label) {
+					continue
+				}
+
+				var plaintext []byte
+				if encFile.IsBundle() {
+					assert.Equal(t, []string{"empty", "moose"}, unsealed.EntryNames())
+					plaintext, err = unsealed.ReadEntry("moose")
+				} else {
+					assert.Equal(t, "moose", unsealed.Filename)
+					plaintext, err = unsealed.Decrypt()
+				}
+				assert.NoError(t, err)
+				assert.Equal(t, vectorPlaintext(t, vector.name), plaintext)
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+const (
+	headerFilename      = "Filename"
+	headerMAC           = "MAC"
+	headerNonce         = "Nonce"
+	headerMode          = "Mode"
+	headerModTime       = "ModTime"
+	headerPadding       = "Padding"
+	headerCompression   = "Compression"
+	headerSize          = "Size"
+	headerRecipients    = "Recipients"
+	headerMetadata      = "Metadata"
+	headerDeterministic = "Deterministic"
+	headerHeaderMAC     = "HeaderMAC"
+
+	headerMACKeyInfo     = "devcrypt header MAC key"
+	metadataKeyInfo      = "devcrypt metadata key"
+	nonceKeyInfo         = "devcrypt nonce key"
+	metadataNonceKeyInfo = "devcrypt metadata nonce key"
+)
+
+var (
+	// legacyHeaders may appear without a HeaderMAC, as written by
+	// devcrypt before headers were authenticated.
+	legacyHeaders = map[string]bool{
+		headerFilename: true,
+		headerMAC:      true,
+		headerNonce:    true,
+	}
+
+	// privateHeaders are moved into the encrypted Metadata header when
+	// HideMetadata is set.
+	privateHeaders = map[string]bool{
+		headerFilename:    true,
+		headerMode:        true,
+		headerModTime:     true,
+		headerPadding:     true,
+		headerCompression: true,
+		headerSize:        true,
+		headerRecipients:  true,
+
+		headerDeterministic: true,
+	}
+
+	errHeaderMACMismatch = errors.New("header authentication failed")
+	errMetadataDecrypt   = errors.New("decrypting metadata failed")
+
+	errKeyBoxOptionsUnauthenticated = errors.New("key box options require a HeaderMAC")
+)
+
+// headers returns the EncFile's PEM headers, excluding the HeaderMAC. When
+// HideMetadata is set, private headers are replaced by the sealed Metadata.
+func (f *EncFile) headers() map[string]string {
+	headers := f.allHeaders()
+	if f.HideMetadata {
+		for name := range privateHeaders {
+			delete(headers, name)
+		}
+		if len(f.metadata) > 0 {
+			headers[headerMetadata] = base64.StdEncoding.EncodeToString(f.metadata)
+		}
+	}
+	return headers
+}
+
+// allHeaders returns the headers for all of the EncFile's fields.
+func (f *EncFile) allHeaders() map[string]string {
+	headers := map[string]string{}
+	if f.Filename != "" {
+		headers[headerFilename] = f.Filename
+	}
+	if len(f.MAC) > 0 {
+		headers[headerMAC] = hex.EncodeToString(f.MAC)
+	}
+	if len(f.nonce) > 0 {
+		headers[headerNonce] = hex.EncodeToString(f.nonce)
+	}
+	if f.Mode != 0 {
+		headers[headerMode] = fmt.Sprintf("%04o", uint32(f.Mode))
+	}
+	if !f.ModTime.IsZero() {
+		headers[headerModTime] = f.ModTime.UTC().Format(time.RFC3339Nano)
+	}
+	if f.Padding != "" {
+		headers[headerPadding] = f.Padding
+	}
+	if f.Compression != "" {
+		headers[headerCompression] = f.Compression
+		if f.originalSize >= 0 {
+			headers[headerSize] = strconv.FormatInt(f.originalSize, 10)
+		}
+	}
+	if f.Deterministic {
+		headers[headerDeterministic] = "true"
+	}
+	var recipients []string
+	for _, keyBox := range f.keyBoxes {
+		if keyBox.anonymous && keyBox.PublicKey != nil {
+			recipients = append(recipients, base64.StdEncoding.EncodeToString([]byte(keyBox.PublicKey.MarshalString())))
+		}
+	}
+	if len(recipients) > 0 {
+		headers[headerRecipients] = strings.Join(recipients, " ")
+	}
+	return headers
+}
+
+// parseHeaders sets the EncFile's fields from PEM headers.
+func (f *EncFile) parseHeaders(headers map[string]string) (err error) {
+	f.Filename, f.MAC, f.nonce = "", nil, nil
+	f.Mode, f.ModTime, f.Padding = 0, time.Time{}, ""
+	f.Compression, f.originalSize = "", -1
+	f.HideMetadata, f.metadata = false, nil
+	f.Deterministic = false
+
+	f.headerMAC, err = hex.DecodeString(headers[headerHeaderMAC])
+	if err != nil {
+		return fmt.Errorf("decoding HeaderMAC: %w", err)
+	}
+
+	for name, value := range headers {
+		if name == headerHeaderMAC {
+			continue
+		}
+		if !legacyHeaders[name] && len(f.headerMAC) == 0 {
+			return fmt.Errorf("header %q requires a HeaderMAC", name)
+		}
+		if err := f.parseHeader(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *EncFile) parseHeader(name, value string) (err error) {
+	switch name {
+	case headerFilename:
+		f.Filename = value
+	case headerMAC:
+		f.MAC, err = hex.DecodeString(value)
+	case headerNonce:
+		f.nonce, err = hex.DecodeString(value)
+	case headerMode:
+		var mode uint64
+		mode, err = strconv.ParseUint(value, 8, 32)
+		f.Mode = os.FileMode(mode)
+	case headerModTime:
+		f.ModTime, err = time.Parse(time.RFC3339Nano, value)
+	case headerPadding:
+		f.Padding = value
+		err = ValidatePadding(value)
+	case headerCompression:
+		f.Compression = value
+		err = ValidateCompression(value)
+	case headerSize:
+		f.originalSize, err = strconv.ParseInt(value, 10, 64)
+		if err == nil && f.originalSize < 0 {
+			err = errors.New("negative size")
+		}
+	case headerDeterministic:
+		f.Deterministic, err = strconv.ParseBool(value)
+	case headerRecipients:
+		err = f.parseRecipients(value)
+	case headerMetadata:
+		f.HideMetadata = true
+		f.metadata, err = base64.StdEncoding.DecodeString(value)
+	default:
+		return fmt.Errorf("unknown header %q", name)
+	}
+	if err != nil {
+		return fmt.Errorf("decoding %s: %w", name, err)
+	}
+	return nil
+}
+
+// parseRecipients sets the PublicKeys of anonymous key boxes, in order.
+func (f *EncFile) parseRecipients(value string) error {
+	var anonKeyBoxes []*KeyBox
+	for _, keyBox := range f.keyBoxes {
+		if keyBox.anonymous {
+			anonKeyBoxes = append(anonKeyBoxes, keyBox)
+		}
+	}
+	encoded := strings.Fields(value)
+	if len(encoded) != len(anonKeyBoxes) {
+		return fmt.Errorf("%d recipients for %d anonymous key boxes", len(encoded), len(anonKeyBoxes))
+	}
+	for i := range encoded {
+		data, err := base64.StdEncoding.DecodeString(encoded[i])
+		if err != nil {
+			return err
+		}
+		pubKey := &PublicKey{}
+		if err := pubKey.UnmarshalString(string(data)); err != nil {
+			return err
+		}
+		anonKeyBoxes[i].PublicKey = pubKey
+	}
+	return nil
+}
+
+// sealMetadata encrypts the private headers into the Metadata header.
+func (f *EncFile) sealMetadata(fileKey *[32]byte, rand io.Reader) error {
+	var buf bytes.Buffer
+	all := f.allHeaders()
+	for _, name := range sortedHeaderNames(all) {
+		if privateHeaders[name] {
+			fmt.Fprintf(&buf, "%s: %s\n", name, all[name])
+		}
+	}
+
+	var nonce [24]byte
+	if f.Deterministic {
+		nonce = syntheticNonce(fileKey, metadataNonceKeyInfo, buf.Bytes())
+	} else if _, err := io.ReadFull(rand, nonce[:]); err != nil {
+		return fmt.Errorf("generating metadata nonce: %w", err)
+	}
+	f.metadata = secretbox.Seal(nonce[:], buf.Bytes(), &nonce, deriveKey(fileKey, metadataKeyInfo))
+	return nil
+}
+
+// openMetadata decrypts the Metadata header into the private header fields.
+func (f *EncFile) openMetadata(fileKey *[32]byte) error {
+	if len(f.metadata) < 24 {
+		return errMetadataDecrypt
+	}
+	var nonce [24]byte
+	copy(nonce[:], f.metadata)
+	plaintext, ok := secretbox.Open(nil, f.metadata[24:], &nonce, deriveKey(fileKey, metadataKeyInfo))
+	if !ok {
+		return errMetadataDecrypt
+	}
+
+	for _, line := range strings.Split(strings.TrimSuffix(string(plaintext), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, ": ", 2)
+		if len(parts) != 2 || !privateHeaders[parts[0]] {
+			return fmt.Errorf("invalid metadata line %q", line)
+		}
+		if err := f.parseHeader(parts[0], parts[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deriveKey derives a purpose-specific key from the file key.
+func deriveKey(fileKey *[32]byte, info string) *[32]byte {
+	mac := hmac.New(sha256.New, fileKey[:])
+	mac.Write([]byte(info))
+	var key [32]byte
+	copy(key[:], mac.Sum(nil))
+	return &key
+}
+
+// syntheticNonce derives a nonce from what it will seal, with a key derived
+// from the file key for that purpose. The same nonce is only ever used again
+// to seal the same data.
+func syntheticNonce(fileKey *[32]byte, info string, data []byte) [24]byte {
+	mac := hmac.New(sha256.New, deriveKey(fileKey, info)[:])
+	mac.Write(data)
+	var nonce [24]byte
+	copy(nonce[:], mac.Sum(nil))
+	return nonce
+}
+
+func sortedHeaderNames(headers map[string]string) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// computeHeaderMAC authenticates the given headers, and any key box options,
+// with a key derived from the file key.
+func computeHeaderMAC(fileKey *[32]byte, headers map[string]string, keyBoxes []*KeyBox) []byte {
+	mac := hmac.New(sha256.New, deriveKey(fileKey, headerMACKeyInfo)[:])
+	for _, name := range sortedHeaderNames(headers) {
+		fmt.Fprintf(mac, "%s: %s\n", name, headers[name])
+	}
+	for _, keyBox := range keyBoxes {
+		if options := keyBox.marshalOptions(); options != "" {
+			fmt.Fprintf(mac, "KeyBox %s: %s\n", keyBox.KeyBase64(), options)
+		}
+	}
+	return mac.Sum(nil)
+}
+
+// authenticateHeaders seals any Metadata and sets the HeaderMAC.
+func (f *EncFile) authenticateHeaders(fileKey *[32]byte, rand io.Reader) error {
+	if f.HideMetadata {
+		if err := f.sealMetadata(fileKey, rand); err != nil {
+			return err
+		}
+	}
+	f.headerMAC = computeHeaderMAC(fileKey, f.headers(), f.keyBoxes)
+	return nil
+}
+
+// verifyHeaders checks the HeaderMAC, if any, and decrypts any Metadata.
+// Files without a HeaderMAC may only have legacy headers, which parseHeaders
+// enforces.
+func (f *EncFile) verifyHeaders(fileKey *[32]byte) error {
+	if len(f.headerMAC) == 0 {
+		if f.HasKeyBoxOptions() {
+			return errKeyBoxOptionsUnauthenticated
+		}
+		return nil
+	}
+	if !hmac.Equal(computeHeaderMAC(fileKey, f.headers(), f.keyBoxes), f.headerMAC) {
+		return errHeaderMACMismatch
+	}
+	if f.HideMetadata && len(f.metadata) > 0 {
+		return f.openMetadata(fileKey)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncFile_MetadataRoundTrip(t *testing.T) {
+	unsealedFile, _, privKey := generateTestUnsealedEncFile(t)
+	unsealedFile.Mode = 0755
+	unsealedFile.ModTime = time.Date(2020, 12, 17, 1, 42, 55, 123, time.UTC)
+
+	buf := &bytes.Buffer{}
+	_, err := unsealedFile.WriteTo(buf)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "Mode: 0755\n")
+	assert.Contains(t, buf.String(), "HeaderMAC: ")
+
+	encFile := &EncFile{}
+	_, err = encFile.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), encFile.Mode)
+	assert.True(t, unsealedFile.ModTime.Equal(encFile.ModTime))
+
+	_, err = encFile.Unseal(privKey)
+	assert.NoError(t, err)
+}
+
+func TestEncFile_TamperedHeader(t *testing.T) {
+	unsealedFile, _, privKey := generateTestUnsealedEncFile(t)
+	unsealedFile.Mode = 0644
+
+	buf := &bytes.Buffer{}
+	_, err := unsealedFile.WriteTo(buf)
+	assert.NoError(t, err)
+	tampered := strings.Replace(buf.String(), "Mode: 0644", "Mode: 4755", 1)
+
+	encFile := &EncFile{}
+	_, err = encFile.ReadFrom(strings.NewReader(tampered))
+	assert.NoError(t, err)
+
+	_, err = encFile.Unseal(privKey)
+	assert.Equal(t, errHeaderMACMismatch, err)
+}
+
+func TestEncFile_UnauthenticatedHeader(t *testing.T) {
+	unsealedFile, _, _ := generateTestUnsealedEncFile(t)
+	unsealedFile.Mode = 0644
+
+	buf := &bytes.Buffer{}
+	_, err := unsealedFile.WriteTo(buf)
+	assert.NoError(t, err)
+
+	// Strip the HeaderMAC line
+	var lines []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if !strings.HasPrefix(line, "HeaderMAC: ") {
+			lines = append(lines, line)
+		}
+	}
+
+	encFile := &EncFile{}
+	_, err = encFile.ReadFrom(strings.NewReader(strings.Join(lines, "\n")))
+	assert.EqualError(t, err, `header "Mode" requires a HeaderMAC`)
+}
+
+func TestEncFile_HideMetadata(t *testing.T) {
+	unsealedFile, _, privKey := generateTestUnsealedEncFile(t)
+	unsealedFile.Mode = 0600
+	unsealedFile.Padding = PaddingPowerOfTwo
+	unsealedFile.HideMetadata = true
+	assert.NoError(t, unsealedFile.Encrypt([]byte("testData")))
+
+	buf := &bytes.Buffer{}
+	_, err := unsealedFile.WriteTo(buf)
+	assert.NoError(t, err)
+	assert.NotContains(t, buf.String(), "testFile")
+	assert.NotContains(t, buf.String(), "Mode: ")
+	assert.NotContains(t, buf.String(), "Padding: ")
+	assert.Contains(t, buf.String(), "Metadata: ")
+
+	encFile := &EncFile{}
+	_, err = encFile.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.True(t, encFile.HideMetadata)
+	assert.Equal(t, "", encFile.Filename)
+	assert.Equal(t, 16, encFile.FileSize())
+
+	unsealed, err := encFile.Unseal(privKey)
+	assert.NoError(t, err)
+	assert.Equal(t, "testFile", unsealed.Filename)
+	assert.Equal(t, os.FileMode(0600), unsealed.Mode)
+	assert.Equal(t, PaddingPowerOfTwo, unsealed.Padding)
+
+	plaintext, err := unsealed.Decrypt()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("testData"), plaintext)
+}
+
+func TestEncFile_KeyBoxMetadata(t *testing.T) {
+	unsealedFile, pubKey, privKey := generateTestUnsealedEncFile(t)
+	metadata := KeyBoxMetadata{AddedBy: "alice", Added: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), Comment: "test"}
+	assert.NoError(t, unsealedFile.SetKeyBoxMetadata(pubKey, metadata))
+	assert.True(t, unsealedFile.HasKeyBoxOptions())
+
+	buf := &bytes.Buffer{}
+	_, err := unsealedFile.WriteTo(buf)
+	assert.NoError(t, err)
+
+	encFile := &EncFile{}
+	_, err = encFile.ReadFrom(bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, metadata, encFile.KeyBoxes()[0].KeyBoxMetadata)
+	_, err = encFile.Unseal(privKey)
+	assert.NoError(t, err)
+
+	// The HeaderMAC covers key box options
+	tampered := strings.Replace(buf.String(), "comment=test", "comment=evil", 1)
+	encFile = &EncFile{}
+	_, err = encFile.ReadFrom(strings.NewReader(tampered))
+	assert.NoError(t, err)
+	_, err = encFile.Unseal(privKey)
+	assert.Equal(t, errHeaderMACMismatch, err)
+
+	assert.Equal(t, errMetadataNewline, unsealedFile.SetKeyBoxMetadata(pubKey, KeyBoxMetadata{Comment: "a\nb"}))
+}
+
+func TestEncFile_AddExpiredKey(t *testing.T) {
+	defer func(orig func() time.Time) { timeNow = orig }(timeNow)
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+
+	unsealedFile, _, _ := generateTestUnsealedEncFile(t)
+	pubKey, _, err := GenerateKeys("expiring")
+	assert.NoError(t, err)
+
+	pubKey.Expires = now
+	assert.Equal(t, ErrKeyExpired, unsealedFile.AddPublicKey(pubKey))
+
+	pubKey.Expires = now.Add(time.Hour)
+	assert.NoError(t, unsealedFile.AddPublicKey(pubKey))
+}
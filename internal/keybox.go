@@ -1,23 +1,88 @@
 package internal
 
 import (
+	"bytes"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
-	keyBoxType = "devcrypt-keybox"
+	keyBoxType          = "devcrypt-keybox"
+	anonymousKeyBoxType = "devcrypt-anon-keybox"
+	shareKeyBoxType     = "devcrypt-share-keybox"
+
+	optionAddedBy = "added-by"
+	optionAdded   = "added"
+	optionComment = "comment"
 )
 
+var errBadShare = errors.New("invalid key share")
+
 // KeyBox stores an encryption key encrypted for a PublicKey.
+//
+// An anonymous KeyBox is stored without its PublicKey, which is only known
+// once the EncFile is unsealed.
 type KeyBox struct {
 	box []byte
 	*PublicKey
+	KeyBoxMetadata
+	anonymous bool
+
+	// A share key box holds share number shareIndex of the file key
+	// instead of the file key itself; see shamir.go.
+	shareIndex byte
+	threshold  int
+}
+
+// KeyBoxMetadata describes a named KeyBox. It is stored in the key box's
+// options prefix and authenticated by the HeaderMAC, so it is only trusted
+// once the EncFile is unsealed. Anonymous key boxes have none.
+type KeyBoxMetadata struct {
+	// AddedBy names who added the key box
+	AddedBy string
+	Added   time.Time
+	Comment string
+}
+
+// Anonymous returns true if the KeyBox doesn't reveal its PublicKey.
+func (b *KeyBox) Anonymous() bool {
+	return b.anonymous
+}
+
+// Threshold returns the number of shares needed to recover the file key if
+// this KeyBox holds a share of it, or else 0.
+func (b *KeyBox) Threshold() int {
+	return b.threshold
+}
+
+// ShareIndex returns the index of the file key share this KeyBox holds, or 0.
+func (b *KeyBox) ShareIndex() int {
+	return int(b.shareIndex)
 }
 
 // MarshalString encodes the KeyBox into a single line.
 func (b *KeyBox) MarshalString() string {
-	return fmt.Sprintf("%s %s %s %s",
+	if b.anonymous {
+		return fmt.Sprintf("%s %s", anonymousKeyBoxType, base64.StdEncoding.EncodeToString(b.box))
+	}
+	if b.threshold > 0 {
+		return fmt.Sprintf("%s%s %d %d %s %s %s",
+			formatOptions(b.options()),
+			shareKeyBoxType,
+			b.threshold,
+			b.shareIndex,
+			base64.StdEncoding.EncodeToString(b.box),
+			b.KeyBase64(),
+			b.Label,
+		)
+	}
+	return fmt.Sprintf("%s%s %s %s %s",
+		formatOptions(b.options()),
 		keyBoxType,
 		base64.StdEncoding.EncodeToString(b.box),
 		b.KeyBase64(),
@@ -27,21 +92,114 @@ func (b *KeyBox) MarshalString() string {
 
 // UnmarshalString decodes the KeyBox from a single line.
 func (b *KeyBox) UnmarshalString(data string) error {
-	fields, err := splitLineFields(data, keyBoxType, 3)
+	if strings.HasPrefix(data, anonymousKeyBoxType+" ") {
+		fields, err := splitLineFields(data, anonymousKeyBoxType, 1)
+		if err != nil {
+			return fmt.Errorf("keybox decode: %w", err)
+		}
+		b.box, err = base64Strict.DecodeString(fields[0])
+		if err != nil {
+			return fmt.Errorf("boxed key decode: %w", fieldError(data, 1, err))
+		}
+		b.PublicKey, b.anonymous = nil, true
+		b.KeyBoxMetadata = KeyBoxMetadata{}
+		return nil
+	}
+
+	options, rest, offset, err := splitOptions(data, keyBoxType, shareKeyBoxType)
 	if err != nil {
 		return fmt.Errorf("keybox decode: %w", err)
 	}
 
-	b.box, err = base64.StdEncoding.DecodeString(fields[0])
+	// A share key box has threshold and share index fields first
+	lineType, skip := keyBoxType, 0
+	if strings.HasPrefix(strings.TrimLeft(rest, " \t"), shareKeyBoxType+" ") {
+		lineType, skip = shareKeyBoxType, 2
+	}
+	fields, err := splitLineFields(rest, lineType, skip+3)
 	if err != nil {
-		return fmt.Errorf("boxed key decode: %w", err)
+		return fmt.Errorf("keybox decode: %w", offsetColumn(err, offset))
+	}
+
+	b.shareIndex, b.threshold = 0, 0
+	if lineType == shareKeyBoxType {
+		threshold, err := strconv.ParseUint(fields[0], 10, 8)
+		if err != nil || threshold < 2 {
+			return fmt.Errorf("share threshold decode: %w", offsetColumn(fieldError(rest, 1, errBadShare), offset))
+		}
+		index, err := strconv.ParseUint(fields[1], 10, 8)
+		if err != nil || index == 0 {
+			return fmt.Errorf("share index decode: %w", offsetColumn(fieldError(rest, 2, errBadShare), offset))
+		}
+		b.shareIndex, b.threshold = byte(index), int(threshold)
+	}
+
+	b.box, err = base64Strict.DecodeString(fields[skip])
+	if err != nil {
+		return fmt.Errorf("boxed key decode: %w", offsetColumn(fieldError(rest, skip+1, err), offset))
 	}
 
 	b.PublicKey = &PublicKey{key: new([32]byte)}
-	if err := decodeBase64Key(b.key, fields[1]); err != nil {
-		return fmt.Errorf("pubkey decode: %w", err)
+	if err := decodeBase64Key(b.key, fields[skip+1]); err != nil {
+		return fmt.Errorf("pubkey decode: %w", offsetColumn(fieldError(rest, skip+2, err), offset))
 	}
 
-	b.Label = fields[2]
+	b.Label = fields[skip+2]
+	b.KeyBoxMetadata = KeyBoxMetadata{}
+	for name, value := range options {
+		if err := b.setOption(name, value); err != nil {
+			return fmt.Errorf("keybox decode: %w", &ParseError{Line: 1, Err: err})
+		}
+	}
+	b.anonymous = false
 	return nil
 }
+
+// marshalOptions returns the encoded options of a named KeyBox, without the
+// trailing space, or "" if there are none.
+func (b *KeyBox) marshalOptions() string {
+	if b.anonymous || b.PublicKey == nil {
+		return ""
+	}
+	return strings.TrimSuffix(formatOptions(b.options()), " ")
+}
+
+// options returns the PublicKey's options followed by the metadata.
+func (b *KeyBox) options() []option {
+	return append(b.PublicKey.options(),
+		option{optionAddedBy, b.AddedBy},
+		option{optionAdded, formatOptionTime(b.Added)},
+		option{optionComment, b.Comment},
+	)
+}
+
+func (b *KeyBox) setOption(name, value string) (err error) {
+	switch name {
+	case optionAddedBy:
+		b.AddedBy = value
+	case optionAdded:
+		b.Added, err = parseOptionTime(name, value)
+	case optionComment:
+		b.Comment = value
+	default:
+		return b.PublicKey.setOption(name, value)
+	}
+	return err
+}
+
+// sortKeyBoxes puts key boxes in canonical order, so that adding or removing
+// a recipient changes only its own line. Named key boxes are sorted by
+// fingerprint, followed by anonymous key boxes sorted by their (random)
+// contents, so their order doesn't hint at their recipients.
+func sortKeyBoxes(keyBoxes []*KeyBox) {
+	sort.SliceStable(keyBoxes, func(i, j int) bool {
+		a, b := keyBoxes[i], keyBoxes[j]
+		if a.anonymous != b.anonymous {
+			return b.anonymous
+		}
+		if a.anonymous || a.PublicKey == nil || b.PublicKey == nil {
+			return bytes.Compare(a.box, b.box) < 0
+		}
+		return a.Fingerprint() < b.Fingerprint()
+	})
+}
@@ -1,7 +1,10 @@
 package internal
 
 import (
+	"encoding/base64"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -32,3 +35,88 @@ func TestKeyBox_UnmarshalString(t *testing.T) {
 	assert.Equal(t, testKey, box.key)
 	assert.Equal(t, testBox, box.box)
 }
+
+func TestKeyBox_Anonymous(t *testing.T) {
+	box := &KeyBox{box: testBox, anonymous: true}
+	data := box.MarshalString()
+	assert.Equal(t, "devcrypt-anon-keybox "+testBoxBase64, data)
+
+	decoded := &KeyBox{}
+	err := decoded.UnmarshalString(data)
+	assert.NoError(t, err)
+	assert.True(t, decoded.Anonymous())
+	assert.Nil(t, decoded.PublicKey)
+	assert.Equal(t, testBox, decoded.box)
+}
+
+func TestKeyBox_UnmarshalStringLongKey(t *testing.T) {
+	box := &KeyBox{}
+	longKey := base64.StdEncoding.EncodeToString(make([]byte, 33))
+	data := "devcrypt-keybox " + testBoxBase64 + " " + longKey + " testLabel"
+	err := box.UnmarshalString(data)
+	var parseErr *ParseError
+	if assert.True(t, errors.As(err, &parseErr), "%v", err) {
+		assert.Equal(t, len("devcrypt-keybox "+testBoxBase64+" ")+1, parseErr.Column)
+	}
+}
+
+func TestKeyBox_Options(t *testing.T) {
+	box := &KeyBox{
+		PublicKey: &PublicKey{Label: "testLabel", Expires: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), key: testKey},
+		KeyBoxMetadata: KeyBoxMetadata{
+			AddedBy: "alice",
+			Added:   time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			Comment: `CI key, "deploy" only`,
+		},
+		box: testBox,
+	}
+	data := box.MarshalString()
+	assert.Equal(t, `expires=2030-01-01T00:00:00Z,added-by=alice,added=2020-01-01T00:00:00Z,comment="CI key, \"deploy\" only" `+
+		"devcrypt-keybox "+testBoxBase64+" "+testKeyBase64+" testLabel", data)
+
+	decoded := &KeyBox{}
+	assert.NoError(t, decoded.UnmarshalString(data))
+	assert.Equal(t, box, decoded)
+
+	// Columns count from the start of the line, including options
+	err := decoded.UnmarshalString(`comment="a b" devcrypt-keybox AA*A ` + testKeyBase64 + " testLabel")
+	var parseErr *ParseError
+	if assert.True(t, errors.As(err, &parseErr), "%v", err) {
+		assert.Equal(t, len(`comment="a b" devcrypt-keybox AA`)+1, parseErr.Column)
+	}
+
+	for _, data := range []string{
+		`comment="unterminated devcrypt-keybox ` + testBoxBase64 + " " + testKeyBase64 + " testLabel",
+		`comment=a,comment=b devcrypt-keybox ` + testBoxBase64 + " " + testKeyBase64 + " testLabel",
+		`added=yesterday devcrypt-keybox ` + testBoxBase64 + " " + testKeyBase64 + " testLabel",
+		`comment=a devcrypt-anon-keybox ` + testBoxBase64,
+	} {
+		assert.Error(t, decoded.UnmarshalString(data), data)
+	}
+}
+
+func TestKeyBox_Share(t *testing.T) {
+	box := &KeyBox{
+		PublicKey:  &PublicKey{Label: "testLabel", key: testKey},
+		box:        testBox,
+		shareIndex: 2,
+		threshold:  3,
+	}
+	data := box.MarshalString()
+	assert.Equal(t, "devcrypt-share-keybox 3 2 "+testBoxBase64+" "+testKeyBase64+" testLabel", data)
+
+	decoded := &KeyBox{}
+	assert.NoError(t, decoded.UnmarshalString(data))
+	assert.Equal(t, box, decoded)
+	assert.Equal(t, 3, decoded.Threshold())
+	assert.Equal(t, 2, decoded.ShareIndex())
+
+	for _, data := range []string{
+		"devcrypt-share-keybox 1 1 " + testBoxBase64 + " " + testKeyBase64 + " testLabel",
+		"devcrypt-share-keybox 3 0 " + testBoxBase64 + " " + testKeyBase64 + " testLabel",
+		"devcrypt-share-keybox 3 256 " + testBoxBase64 + " " + testKeyBase64 + " testLabel",
+		"devcrypt-share-keybox 3 " + testBoxBase64 + " " + testKeyBase64 + " testLabel",
+	} {
+		assert.Error(t, decoded.UnmarshalString(data), data)
+	}
+}
@@ -3,11 +3,13 @@ package internal
 import (
 	"bytes"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/curve25519"
 	"golang.org/x/crypto/nacl/box"
@@ -16,9 +18,15 @@ import (
 const (
 	privateKeyBlockType = "DEVCRYPT PRIVATE KEY"
 	keyType             = "devcrypt-key"
+
+	optionExpires = "expires"
+	optionScope   = "scope"
 )
 
 var (
+	// ErrKeyExpired means the public key's expiry time has passed
+	ErrKeyExpired = errors.New("public key expired")
+
 	errBadKeyEncoding = errors.New("invalid key encoding")
 	errLabelNewline   = errors.New("labels may not contain newlines")
 )
@@ -46,7 +54,20 @@ func GenerateKeys(label string) (*PublicKey, *PrivateKey, error) {
 // PublicKey stores the public key and label.
 type PublicKey struct {
 	Label string
-	key   *[32]byte
+
+	// Expires is when the key should stop being used; zero means never.
+	// It is advisory: an expired key can still open its key boxes.
+	Expires time.Time
+
+	// Scope optionally names what the key is for, e.g. "ci".
+	Scope string
+
+	key *[32]byte
+}
+
+// Expired returns true if the key has expired at the given time.
+func (k *PublicKey) Expired(now time.Time) bool {
+	return !k.Expires.IsZero() && !now.Before(k.Expires)
 }
 
 // KeyBase64 returns the base64-encoded public key.
@@ -54,9 +75,18 @@ func (k *PublicKey) KeyBase64() string {
 	return base64.StdEncoding.EncodeToString(k.key[:])
 }
 
-// MarshalString encodes the PublicKey into a single line like SSH's authorized_keys.
+// Fingerprint returns a short, stable identifier for the public key, like
+// SSH's SHA256 fingerprints. It doesn't depend on the Label.
+func (k *PublicKey) Fingerprint() string {
+	sum := sha256.Sum256(k.key[:])
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// MarshalString encodes the PublicKey into a single line like SSH's
+// authorized_keys, with any expiry in an options prefix.
 func (k *PublicKey) MarshalString() string {
-	return fmt.Sprintf("%s %s %s",
+	return fmt.Sprintf("%s%s %s %s",
+		formatOptions(k.options()),
 		keyType,
 		k.KeyBase64(),
 		k.Label,
@@ -65,32 +95,89 @@ func (k *PublicKey) MarshalString() string {
 
 // UnmarshalString decodes the PublicKey from a single line.
 func (k *PublicKey) UnmarshalString(data string) error {
-	fields, err := splitLineFields(data, keyType, 2)
+	options, rest, offset, err := splitOptions(data, keyType)
 	if err != nil {
 		return fmt.Errorf("key decode: %w", err)
 	}
+	k.Expires, k.Scope = time.Time{}, ""
+	for name, value := range options {
+		if err := k.setOption(name, value); err != nil {
+			return fmt.Errorf("key decode: %w", &ParseError{Line: 1, Err: err})
+		}
+	}
+
+	fields, err := splitLineFields(rest, keyType, 2)
+	if err != nil {
+		return fmt.Errorf("key decode: %w", offsetColumn(err, offset))
+	}
 
 	k.key = new([32]byte)
 	if err := decodeBase64Key(k.key, fields[0]); err != nil {
-		return fmt.Errorf("decode public key: %w", err)
+		return fmt.Errorf("decode public key: %w", offsetColumn(fieldError(rest, 1, err), offset))
 	}
 	k.Label = fields[1]
 	return nil
 }
 
+func (k *PublicKey) options() []option {
+	return []option{
+		{optionExpires, formatOptionTime(k.Expires)},
+		{optionScope, k.Scope},
+	}
+}
+
+// setOption sets a field from one of the options returned by options.
+func (k *PublicKey) setOption(name, value string) (err error) {
+	switch name {
+	case optionExpires:
+		k.Expires, err = parseOptionTime(name, value)
+		return err
+	case optionScope:
+		k.Scope = value
+		return nil
+	}
+	return fmt.Errorf("unknown option %q", name)
+}
+
+// Identity can open KeyBoxes sealed to its Recipient PublicKey.
+type Identity interface {
+	Recipient() *PublicKey
+	OpenKeyBox(keyBox *KeyBox) (*[32]byte, error)
+}
+
 // PrivateKey stores the private key and label.
 type PrivateKey struct {
 	Label string
-	key   *[32]byte
+
+	// Expires and Scope are copied to the Recipient PublicKey.
+	Expires time.Time
+	Scope   string
+
+	key *[32]byte
 }
 
 func (k *PrivateKey) publicKey() *PublicKey {
 	// DANGER: this depends on the undocumented internals of golang.org/x/crypto/nacl/box !!!
-	pubKey := &PublicKey{Label: k.Label, key: new([32]byte)}
+	pubKey := &PublicKey{Label: k.Label, Expires: k.Expires, Scope: k.Scope, key: new([32]byte)}
 	curve25519.ScalarBaseMult(pubKey.key, k.key)
 	return pubKey
 }
 
+// Recipient returns the PublicKey matching this PrivateKey.
+func (k *PrivateKey) Recipient() *PublicKey {
+	return k.publicKey()
+}
+
+// OpenKeyBox decrypts the file key from a KeyBox sealed to this PrivateKey.
+func (k *PrivateKey) OpenKeyBox(keyBox *KeyBox) (*[32]byte, error) {
+	var fileKey [32]byte
+	out, ok := box.OpenAnonymous(fileKey[:0], keyBox.box, keyBox.PublicKey.key, k.key)
+	if !ok || len(out) != len(fileKey) {
+		return nil, fmt.Errorf("unboxing key failed with private key %q", k.Label)
+	}
+	return &fileKey, nil
+}
+
 // Marshal encodes the PrivateKey into a PEM block.
 func (k *PrivateKey) Marshal() ([]byte, error) {
 	block := &pem.Block{
@@ -98,6 +185,12 @@ func (k *PrivateKey) Marshal() ([]byte, error) {
 		Headers: map[string]string{"Label": k.Label},
 		Bytes:   k.key[:],
 	}
+	if !k.Expires.IsZero() {
+		block.Headers["Expires"] = formatOptionTime(k.Expires)
+	}
+	if k.Scope != "" {
+		block.Headers["Scope"] = k.Scope
+	}
 	var buf bytes.Buffer
 	if err := pem.Encode(&buf, block); err != nil {
 		return nil, err
@@ -111,13 +204,32 @@ func (k *PrivateKey) Unmarshal(blockBytes []byte) error {
 	if block == nil || len(bytes.TrimSpace(rest)) != 0 {
 		return errBadKeyEncoding
 	}
+	if block.Type != privateKeyBlockType || len(block.Bytes) != 32 {
+		return errBadKeyEncoding
+	}
 	k.Label = block.Headers["Label"]
+	k.Expires, k.Scope = time.Time{}, block.Headers["Scope"]
+	if expires, ok := block.Headers["Expires"]; ok {
+		var err error
+		if k.Expires, err = time.Parse(time.RFC3339, expires); err != nil {
+			return errBadKeyEncoding
+		}
+	}
 	if k.key == nil {
 		k.key = new([32]byte)
 	}
-	n := copy(k.key[:], block.Bytes)
-	if n != len(k.key) {
-		return errBadKeyEncoding
+	copy(k.key[:], block.Bytes)
+	return nil
+}
+
+// UnmarshalBase64 decodes the PrivateKey from a bare base64-encoded key. The
+// Label is left unchanged.
+func (k *PrivateKey) UnmarshalBase64(data string) error {
+	if k.key == nil {
+		k.key = new([32]byte)
+	}
+	if err := decodeBase64Key(k.key, strings.TrimSpace(data)); err != nil {
+		return fmt.Errorf("decode private key: %w", err)
 	}
 	return nil
 }
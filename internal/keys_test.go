@@ -2,6 +2,7 @@ package internal
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -66,3 +67,45 @@ func TestPrivateKey_Unmarshal(t *testing.T) {
 	assert.Equal(t, "testLabel", privKey.Label)
 	assert.Equal(t, testKey, privKey.key)
 }
+
+func TestPrivateKey_UnmarshalBase64(t *testing.T) {
+	privKey := &PrivateKey{Label: "testLabel"}
+	err := privKey.UnmarshalBase64(testKeyBase64 + "\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "testLabel", privKey.Label)
+	assert.Equal(t, testKey, privKey.key)
+
+	err = privKey.UnmarshalBase64("AQID")
+	assert.Error(t, err)
+}
+
+func TestPublicKey_Expires(t *testing.T) {
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	pubKey := &PublicKey{Label: "test label", Expires: expires, Scope: "ci", key: testKey}
+	data := pubKey.MarshalString()
+	assert.Equal(t, "expires=2030-01-02T03:04:05Z,scope=ci devcrypt-key "+testKeyBase64+" test label", data)
+
+	decoded := &PublicKey{}
+	assert.NoError(t, decoded.UnmarshalString(data))
+	assert.Equal(t, pubKey, decoded)
+
+	assert.False(t, decoded.Expired(expires.Add(-time.Second)))
+	assert.True(t, decoded.Expired(expires))
+	assert.False(t, (&PublicKey{}).Expired(expires))
+
+	err := decoded.UnmarshalString("colour=blue devcrypt-key " + testKeyBase64 + " testLabel")
+	assert.EqualError(t, err, `key decode: line 1: unknown option "colour"`)
+}
+
+func TestPrivateKey_Expires(t *testing.T) {
+	privKey := &PrivateKey{Label: "testLabel", Expires: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC), Scope: "ci", key: testKey}
+	data, err := privKey.Marshal()
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "Expires: 2030-01-02T03:04:05Z\n")
+
+	decoded := &PrivateKey{}
+	assert.NoError(t, decoded.Unmarshal(data))
+	assert.Equal(t, privKey.Expires, decoded.Expires)
+	assert.Equal(t, privKey.Expires, decoded.Recipient().Expires)
+	assert.Equal(t, "ci", decoded.Recipient().Scope)
+}
@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A recovery phrase encodes a private key's 32 bytes, as BIP 39 encodes 256
+// bits of entropy: the bytes and the first byte of their SHA-256 hash are
+// split into 24 11-bit indexes into the BIP 39 English word list. The key
+// isn't derived from the phrase through a hash, so any private key, not only
+// one generated with a phrase, can be written down and recovered exactly.
+
+const mnemonicLength = 24
+
+var (
+	errMnemonicLength   = fmt.Errorf("recovery phrase must have %d words", mnemonicLength)
+	errMnemonicWord     = errors.New("unknown word in recovery phrase")
+	errMnemonicChecksum = errors.New("recovery phrase checksum mismatch; check the words and their order")
+)
+
+// mnemonicIndex maps each word, and its first four letters, to its index.
+var mnemonicIndex = func() map[string]int {
+	index := make(map[string]int, 2*len(mnemonicWords))
+	for i, word := range mnemonicWords {
+		index[word] = i
+		if len(word) > 4 {
+			index[word[:4]] = i
+		}
+	}
+	return index
+}()
+
+// Mnemonic returns the PrivateKey's recovery phrase.
+func (k *PrivateKey) Mnemonic() string {
+	return strings.Join(encodeMnemonic(k.key), " ")
+}
+
+// PrivateKeyFromMnemonic recovers the PrivateKey whose recovery phrase is
+// phrase. Words may be abbreviated to their first four letters.
+func PrivateKeyFromMnemonic(label, phrase string) (*PrivateKey, error) {
+	if strings.ContainsRune(label, '\n') {
+		return nil, errLabelNewline
+	}
+	key, err := decodeMnemonic(strings.Fields(phrase))
+	if err != nil {
+		return nil, err
+	}
+	return &PrivateKey{Label: label, key: key}, nil
+}
+
+func encodeMnemonic(key *[32]byte) []string {
+	checksum := sha256.Sum256(key[:])
+	data := append(key[:len(key):len(key)], checksum[0])
+
+	words := make([]string, mnemonicLength)
+	for i := range words {
+		var index int
+		for bit := i * 11; bit < (i+1)*11; bit++ {
+			index = index<<1 | int(data[bit/8]>>(7-bit%8)&1)
+		}
+		words[i] = mnemonicWords[index]
+	}
+	return words
+}
+
+func decodeMnemonic(words []string) (*[32]byte, error) {
+	if len(words) != mnemonicLength {
+		return nil, errMnemonicLength
+	}
+
+	var data [33]byte
+	for i, word := range words {
+		index, ok := mnemonicIndex[strings.ToLower(word)]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q (word %d)", errMnemonicWord, word, i+1)
+		}
+		for j := 0; j < 11; j++ {
+			bit := i*11 + j
+			data[bit/8] |= byte(index>>(10-j)&1) << (7 - bit%8)
+		}
+	}
+
+	key := new([32]byte)
+	copy(key[:], data[:32])
+	if checksum := sha256.Sum256(key[:]); checksum[0] != data[32] {
+		return nil, errMnemonicChecksum
+	}
+	return key, nil
+}
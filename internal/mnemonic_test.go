@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMnemonic_WordList(t *testing.T) {
+	list := strings.Join(mnemonicWords, "\n") + "\n"
+	sum := sha256.Sum256([]byte(list))
+	assert.Equal(t, "2f5eed53a4727b4bf8880d8f3f199efc90e58503646d9ff8eff3a2ed3b24dbda", hex.EncodeToString(sum[:]))
+
+	// Abbreviations are unambiguous
+	abbrevs := map[string]bool{}
+	for _, word := range mnemonicWords {
+		if len(word) >= 4 {
+			assert.False(t, abbrevs[word[:4]], word)
+			abbrevs[word[:4]] = true
+		}
+	}
+}
+
+// BIP 39 test vectors for 256-bit entropy
+func TestMnemonic_Vectors(t *testing.T) {
+	for _, tc := range []struct {
+		key    byte
+		phrase string
+	}{
+		{0x00, "abandon abandon abandon abandon abandon abandon abandon abandon " +
+			"abandon abandon abandon abandon abandon abandon abandon abandon " +
+			"abandon abandon abandon abandon abandon abandon abandon art"},
+		{0x7f, "legal winner thank year wave sausage worth useful " +
+			"legal winner thank year wave sausage worth useful " +
+			"legal winner thank year wave sausage worth title"},
+		{0x80, "letter advice cage absurd amount doctor acoustic avoid " +
+			"letter advice cage absurd amount doctor acoustic avoid " +
+			"letter advice cage absurd amount doctor acoustic bless"},
+		{0xff, "zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo " +
+			"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo vote"},
+	} {
+		var key [32]byte
+		copy(key[:], bytes.Repeat([]byte{tc.key}, 32))
+		privKey := &PrivateKey{key: &key}
+		assert.Equal(t, tc.phrase, privKey.Mnemonic())
+
+		recovered, err := PrivateKeyFromMnemonic("testLabel", tc.phrase)
+		if assert.NoError(t, err) {
+			assert.Equal(t, key, *recovered.key)
+		}
+	}
+}
+
+func TestPrivateKeyFromMnemonic(t *testing.T) {
+	pubKey, privKey, err := GenerateKeys("testLabel")
+	assert.NoError(t, err)
+
+	// Recovery accepts any case, spacing and four-letter abbreviations
+	var words []string
+	for i, word := range strings.Fields(privKey.Mnemonic()) {
+		if i%2 == 0 && len(word) > 4 {
+			word = word[:4]
+		}
+		words = append(words, strings.ToUpper(word))
+	}
+	recovered, err := PrivateKeyFromMnemonic("testLabel", strings.Join(words, "\n "))
+	if assert.NoError(t, err) {
+		assert.Equal(t, pubKey, recovered.Recipient())
+	}
+
+	_, err = PrivateKeyFromMnemonic("testLabel", strings.Join(words[1:], " "))
+	assert.Equal(t, errMnemonicLength, err)
+
+	words[3] = "notaword"
+	_, err = PrivateKeyFromMnemonic("testLabel", strings.Join(words, " "))
+	assert.True(t, errors.Is(err, errMnemonicWord))
+	assert.Contains(t, err.Error(), "word 4")
+
+	// Swapping two words breaks this checksum
+	phrase := strings.Repeat("abandon ", 22) + "art abandon"
+	_, err = PrivateKeyFromMnemonic("testLabel", phrase)
+	assert.Equal(t, errMnemonicChecksum, err)
+}
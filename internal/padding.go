@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+const (
+	// PaddingPowerOfTwo pads plaintext up to the next power of two.
+	PaddingPowerOfTwo = "pow2"
+)
+
+var (
+	errBadPadding = errors.New("invalid padding")
+)
+
+// ValidatePadding checks a padding mode: "" for none, PaddingPowerOfTwo, or a
+// decimal bucket size that plaintext is padded to a multiple of.
+func ValidatePadding(mode string) error {
+	_, err := paddingBucket(mode)
+	return err
+}
+
+// paddingBucket returns the bucket size for a padding mode, 0 for none or -1
+// for PaddingPowerOfTwo.
+func paddingBucket(mode string) (int, error) {
+	switch mode {
+	case "":
+		return 0, nil
+	case PaddingPowerOfTwo:
+		return -1, nil
+	}
+	bucket, err := strconv.Atoi(mode)
+	if err != nil || bucket < 1 {
+		return 0, fmt.Errorf("unknown padding mode %q", mode)
+	}
+	return bucket, nil
+}
+
+// pad appends a 0x80 byte and then zeros up to the size given by the mode
+// (as in ISO/IEC 7816-4), so that the padding can be removed unambiguously.
+func pad(plaintext []byte, mode string) ([]byte, error) {
+	bucket, err := paddingBucket(mode)
+	if err != nil || bucket == 0 {
+		return plaintext, err
+	}
+
+	minSize := len(plaintext) + 1
+	size := 1
+	if bucket < 0 {
+		for size < minSize {
+			size *= 2
+		}
+	} else {
+		size = (minSize + bucket - 1) / bucket * bucket
+	}
+
+	padded := make([]byte, size)
+	copy(padded, plaintext)
+	padded[len(plaintext)] = 0x80
+	return padded, nil
+}
+
+// unpad removes padding added by pad.
+func unpad(padded []byte, mode string) ([]byte, error) {
+	if mode == "" {
+		return padded, nil
+	}
+	for i := len(padded) - 1; i >= 0; i-- {
+		switch padded[i] {
+		case 0:
+			continue
+		case 0x80:
+			return padded[:i], nil
+		}
+		break
+	}
+	return nil, errBadPadding
+}
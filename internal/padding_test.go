@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPad(t *testing.T) {
+	for _, tc := range []struct {
+		mode     string
+		inputLen int
+		padLen   int
+	}{
+		{"", 5, 5},
+		{PaddingPowerOfTwo, 0, 1},
+		{PaddingPowerOfTwo, 5, 8},
+		{PaddingPowerOfTwo, 8, 16},
+		{"16", 5, 16},
+		{"16", 15, 16},
+		{"16", 16, 32},
+	} {
+		input := make([]byte, tc.inputLen)
+		for i := range input {
+			input[i] = 0x80
+		}
+
+		padded, err := pad(input, tc.mode)
+		assert.NoError(t, err)
+		assert.Len(t, padded, tc.padLen, "mode %q, input length %d", tc.mode, tc.inputLen)
+
+		unpadded, err := unpad(padded, tc.mode)
+		assert.NoError(t, err)
+		assert.Equal(t, input, unpadded)
+	}
+}
+
+func TestUnpad_Invalid(t *testing.T) {
+	_, err := unpad([]byte{1, 0, 0}, PaddingPowerOfTwo)
+	assert.Equal(t, errBadPadding, err)
+
+	_, err = unpad([]byte{}, PaddingPowerOfTwo)
+	assert.Equal(t, errBadPadding, err)
+}
+
+func TestValidatePadding(t *testing.T) {
+	assert.NoError(t, ValidatePadding(""))
+	assert.NoError(t, ValidatePadding(PaddingPowerOfTwo))
+	assert.NoError(t, ValidatePadding("4096"))
+	assert.Error(t, ValidatePadding("0"))
+	assert.Error(t, ValidatePadding("pow3"))
+}
@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+func TestEncrypt_MatchesSerial(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	testData := make([]byte, chunkSize*5+10)
+	_, err := rand.Read(testData)
+	assert.NoError(t, err)
+
+	unsealedFile, err := NewUnsealedEncFile("testFile")
+	assert.NoError(t, err)
+	assert.NoError(t, unsealedFile.Encrypt(testData))
+
+	// Seal serially, as Encrypt originally did, counting up from the nonce
+	counter := binary.LittleEndian.Uint64(unsealedFile.nonce)
+	var counterBytes [24]byte
+	copy(counterBytes[:], unsealedFile.nonce)
+
+	var serial []byte
+	for i := 0; i*chunkSize < len(testData); i++ {
+		end := (i + 1) * chunkSize
+		if end > len(testData) {
+			end = len(testData)
+		}
+		binary.LittleEndian.PutUint64(counterBytes[:], counter)
+		counter++
+		serial = secretbox.Seal(serial, testData[i*chunkSize:end], &counterBytes, unsealedFile.fileKey)
+	}
+	assert.Equal(t, serial, unsealedFile.ciphertext)
+
+	plaintext, err := unsealedFile.Decrypt()
+	assert.NoError(t, err)
+	assert.Equal(t, testData, plaintext)
+
+	// Corrupt a middle chunk
+	unsealedFile.ciphertext[cipherChunkSize*2+5] ^= 1
+	_, err = unsealedFile.Decrypt()
+	assert.EqualError(t, err, "decrypt failed")
+}
+
+func TestForEachChunk_Error(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	testErr := errors.New("test")
+	err := forEachChunk(100, func(i int) error {
+		if i == 50 {
+			return testErr
+		}
+		return nil
+	})
+	assert.Equal(t, testErr, err)
+}
+
+func benchmarkFile(b *testing.B, size int) (*UnsealedEncFile, []byte) {
+	testData := make([]byte, size)
+	_, err := rand.Read(testData)
+	assert.NoError(b, err)
+
+	unsealedFile, err := NewUnsealedEncFile("testFile")
+	assert.NoError(b, err)
+	b.SetBytes(int64(size))
+	return unsealedFile, testData
+}
+
+// Compare worker counts with e.g. go test -bench . -cpu 1,4 ./internal
+func BenchmarkEncrypt(b *testing.B) {
+	unsealedFile, testData := benchmarkFile(b, 64<<20)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := unsealedFile.Encrypt(testData); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecrypt(b *testing.B) {
+	unsealedFile, testData := benchmarkFile(b, 64<<20)
+	assert.NoError(b, unsealedFile.Encrypt(testData))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := unsealedFile.Decrypt(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+var (
+	// ErrNoRandomAccess means the file's plaintext can't be read at an
+	// offset without decrypting all of it
+	ErrNoRandomAccess = errors.New("compressed files don't support random access")
+
+	errNegativeOffset = errors.New("negative offset")
+)
+
+// PlaintextReader reads an unsealed file's plaintext at any offset,
+// decrypting only the chunks it needs. Each chunk is authenticated as it is
+// decrypted, but since the whole plaintext isn't read, its MAC isn't checked.
+//
+// ReadAt may be called concurrently; Read and Seek may not.
+type PlaintextReader struct {
+	f      *UnsealedEncFile
+	size   int64
+	offset int64
+
+	// The most recently decrypted chunk
+	mu         sync.Mutex
+	chunkIndex int
+	chunk      []byte
+}
+
+// NewReader returns a PlaintextReader for the file's plaintext.
+func (f *UnsealedEncFile) NewReader() (*PlaintextReader, error) {
+	if f.bundle {
+		return nil, ErrBundle
+	}
+	if f.Compression != "" {
+		return nil, ErrNoRandomAccess
+	}
+	r := &PlaintextReader{f: f, size: int64(f.FileSize()), chunkIndex: -1}
+	if f.Padding != "" {
+		if err := r.findPadding(); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// findPadding sets the size to exclude padding, which ends the last chunks.
+func (r *PlaintextReader) findPadding() error {
+	for i := r.f.chunkCount() - 1; i >= 0; i-- {
+		chunk, err := r.getChunk(i)
+		if err != nil {
+			return err
+		}
+		for j := len(chunk) - 1; j >= 0; j-- {
+			switch chunk[j] {
+			case 0:
+				continue
+			case 0x80:
+				r.size = int64(i)*chunkSize + int64(j)
+				return nil
+			}
+			return errBadPadding
+		}
+	}
+	return errBadPadding
+}
+
+// Size returns the plaintext size.
+func (r *PlaintextReader) Size() int64 {
+	return r.size
+}
+
+// ReadAt implements io.ReaderAt.
+func (r *PlaintextReader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errNegativeOffset
+	}
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= r.size {
+			return n, io.EOF
+		}
+		chunk, err := r.getChunk(int(pos / chunkSize))
+		if err != nil {
+			return n, err
+		}
+		chunkEnd := int64(len(chunk))
+		if remaining := r.size - pos + pos%chunkSize; remaining < chunkEnd {
+			chunkEnd = remaining
+		}
+		n += copy(p[n:], chunk[pos%chunkSize:chunkEnd])
+	}
+	return n, nil
+}
+
+// Read implements io.Reader.
+func (r *PlaintextReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err := r.ReadAt(p, r.offset)
+	r.offset += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker.
+func (r *PlaintextReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, errNegativeOffset
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// getChunk returns the i'th plaintext chunk, which must not be modified.
+func (r *PlaintextReader) getChunk(i int) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i != r.chunkIndex {
+		chunk, err := r.f.openChunk(nil, i)
+		if err != nil {
+			return nil, err
+		}
+		r.chunkIndex, r.chunk = i, chunk
+	}
+	return r.chunk, nil
+}
@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlaintextReader(t *testing.T) {
+	for _, tc := range []struct {
+		size    int
+		padding string
+	}{
+		{0, ""},
+		{10, ""},
+		{chunkSize, ""},
+		{chunkSize*2 + 10, ""},
+		{chunkSize + 10, PaddingPowerOfTwo},
+		{10, "100000"},
+	} {
+		testData := make([]byte, tc.size)
+		_, err := rand.Read(testData)
+		assert.NoError(t, err)
+
+		unsealedFile, err := NewUnsealedEncFile("testFile")
+		assert.NoError(t, err)
+		unsealedFile.Padding = tc.padding
+		assert.NoError(t, unsealedFile.Encrypt(testData))
+
+		r, err := unsealedFile.NewReader()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(tc.size), r.Size(), "size %d, padding %q", tc.size, tc.padding)
+
+		all, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, testData, all)
+
+		if tc.size > 20 {
+			// Span a chunk boundary where possible
+			off := int64(tc.size / 2)
+			if tc.size > chunkSize {
+				off = chunkSize - 5
+			}
+			buf := make([]byte, 10)
+			n, err := r.ReadAt(buf, off)
+			assert.NoError(t, err)
+			assert.Equal(t, 10, n)
+			assert.Equal(t, testData[off:off+10], buf)
+
+			n, err = r.ReadAt(buf, int64(tc.size-5))
+			assert.Equal(t, io.EOF, err)
+			assert.Equal(t, testData[tc.size-5:], buf[:n])
+
+			pos, err := r.Seek(-5, io.SeekEnd)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(tc.size-5), pos)
+			rest, err := ioutil.ReadAll(r)
+			assert.NoError(t, err)
+			assert.Equal(t, testData[tc.size-5:], rest)
+		}
+	}
+}
+
+func TestPlaintextReader_Compressed(t *testing.T) {
+	unsealedFile, _, _ := generateTestUnsealedEncFile(t)
+	unsealedFile.Compression = CompressionGzip
+
+	_, err := unsealedFile.NewReader()
+	assert.Equal(t, ErrNoRandomAccess, err)
+}
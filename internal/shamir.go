@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Shamir secret sharing over GF(2^8), byte by byte: each byte of the secret
+// is the constant term of a random polynomial of degree threshold-1, and
+// share x holds each polynomial's value at x. Any threshold shares determine
+// the polynomials, and so the secret; fewer reveal nothing about it.
+//
+// Field arithmetic uses the AES polynomial x^8 + x^4 + x^3 + x + 1, without
+// lookup tables so its timing doesn't depend on the secret.
+
+var (
+	errShareCount     = errors.New("need between 2 and 255 shares")
+	errDuplicateShare = errors.New("duplicate share")
+)
+
+// gfMul multiplies in GF(2^8).
+func gfMul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= -(b & 1) & a
+		a = a<<1 ^ -(a>>7)&0x1b
+		b >>= 1
+	}
+	return p
+}
+
+// gfInv returns the multiplicative inverse of a non-zero a, as a^254.
+func gfInv(a byte) byte {
+	b := gfMul(a, a) // a^2
+	c := gfMul(a, b) // a^3
+	b = gfMul(c, c)  // a^6
+	b = gfMul(b, b)  // a^12
+	c = gfMul(b, c)  // a^15
+	b = gfMul(b, b)  // a^24
+	b = gfMul(b, b)  // a^48
+	b = gfMul(b, c)  // a^63
+	b = gfMul(b, b)  // a^126
+	b = gfMul(a, b)  // a^127
+	return gfMul(b, b)
+}
+
+// splitSecret splits secret into n shares, any threshold of which recover
+// it. Share i is for x = i+1.
+func splitSecret(secret []byte, n, threshold int, rand io.Reader) ([][]byte, error) {
+	if n < 2 || n > 255 {
+		return nil, errShareCount
+	}
+	if threshold < 2 || threshold > n {
+		return nil, fmt.Errorf("threshold must be between 2 and %d", n)
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret))
+	}
+	coefficients := make([]byte, threshold-1)
+	for j, s := range secret {
+		if _, err := io.ReadFull(rand, coefficients); err != nil {
+			return nil, fmt.Errorf("generating coefficients: %w", err)
+		}
+		for i := range shares {
+			// Horner's method
+			x := byte(i + 1)
+			var y byte
+			for k := len(coefficients) - 1; k >= 0; k-- {
+				y = gfMul(y^coefficients[k], x)
+			}
+			shares[i][j] = y ^ s
+		}
+	}
+	for i := range coefficients {
+		coefficients[i] = 0
+	}
+	return shares, nil
+}
+
+// combineShares recovers a secret from shares at the given non-zero,
+// distinct xs by Lagrange interpolation at 0. With fewer than the threshold
+// shares the result is garbage, not an error.
+func combineShares(xs []byte, shares [][]byte) ([]byte, error) {
+	if len(xs) != len(shares) || len(xs) == 0 {
+		return nil, errShareCount
+	}
+	for i, x := range xs {
+		if x == 0 {
+			return nil, fmt.Errorf("invalid share index 0")
+		}
+		if len(shares[i]) != len(shares[0]) {
+			return nil, fmt.Errorf("share lengths differ")
+		}
+		for _, other := range xs[:i] {
+			if x == other {
+				return nil, errDuplicateShare
+			}
+		}
+	}
+
+	secret := make([]byte, len(shares[0]))
+	for i, xi := range xs {
+		// Lagrange basis polynomial for xi, evaluated at 0
+		basis := byte(1)
+		for _, xj := range xs {
+			if xj != xi {
+				basis = gfMul(basis, gfMul(xj, gfInv(xj^xi)))
+			}
+		}
+		for j := range secret {
+			secret[j] ^= gfMul(basis, shares[i][j])
+		}
+	}
+	return secret, nil
+}
@@ -0,0 +1,46 @@
+package internal
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGF256(t *testing.T) {
+	assert.Equal(t, byte(0xc1), gfMul(0x57, 0x83)) // FIPS 197 example
+	for a := 1; a < 256; a++ {
+		assert.Equal(t, byte(1), gfMul(byte(a), gfInv(byte(a))), "a=%d", a)
+	}
+}
+
+func TestShamir_SplitCombine(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	shares, err := splitSecret(secret, 5, 3, rand.Reader)
+	assert.NoError(t, err)
+	assert.Len(t, shares, 5)
+
+	// Any 3 shares recover the secret
+	for _, xs := range [][]byte{{1, 2, 3}, {5, 3, 1}, {2, 4, 5}, {1, 2, 3, 4, 5}} {
+		var picked [][]byte
+		for _, x := range xs {
+			picked = append(picked, shares[x-1])
+		}
+		combined, err := combineShares(xs, picked)
+		assert.NoError(t, err)
+		assert.Equal(t, secret, combined, "xs=%v", xs)
+	}
+
+	// 2 don't
+	combined, err := combineShares([]byte{1, 2}, shares[:2])
+	assert.NoError(t, err)
+	assert.NotEqual(t, secret, combined)
+
+	_, err = combineShares([]byte{1, 1}, [][]byte{shares[0], shares[0]})
+	assert.Equal(t, errDuplicateShare, err)
+
+	_, err = splitSecret(secret, 5, 6, rand.Reader)
+	assert.Error(t, err)
+	_, err = splitSecret(secret, 1, 1, rand.Reader)
+	assert.Equal(t, errShareCount, err)
+}
@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+const keyShareBlockType = "DEVCRYPT KEY SHARE"
+
+var (
+	errNotEnoughShares = errors.New("not enough key shares")
+	errSharesMismatch  = errors.New("key shares don't recover this file's key")
+)
+
+// KeyShare is one share of an EncFile's file key, opened from a share key
+// box. Threshold shares together recover the file key, so a KeyShare must
+// be kept as secret as a private key until it is used.
+type KeyShare struct {
+	Index     int
+	Threshold int
+
+	// Label of the share key box's PublicKey
+	Label string
+
+	share [32]byte
+}
+
+// Marshal encodes the KeyShare into a PEM block.
+func (s *KeyShare) Marshal() ([]byte, error) {
+	block := &pem.Block{
+		Type: keyShareBlockType,
+		Headers: map[string]string{
+			"Index":     strconv.Itoa(s.Index),
+			"Threshold": strconv.Itoa(s.Threshold),
+			"Label":     s.Label,
+		},
+		Bytes: s.share[:],
+	}
+	var buf bytes.Buffer
+	if err := pem.Encode(&buf, block); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes the KeyShare from a PEM block.
+func (s *KeyShare) Unmarshal(data []byte) error {
+	block, rest := pem.Decode(data)
+	if block == nil || len(bytes.TrimSpace(rest)) != 0 || block.Type != keyShareBlockType {
+		return errBadShare
+	}
+	index, err := strconv.ParseUint(block.Headers["Index"], 10, 8)
+	if err != nil || index == 0 {
+		return errBadShare
+	}
+	threshold, err := strconv.ParseUint(block.Headers["Threshold"], 10, 8)
+	if err != nil || threshold < 2 {
+		return errBadShare
+	}
+	if len(block.Bytes) != len(s.share) {
+		return errBadShare
+	}
+	s.Index, s.Threshold, s.Label = int(index), int(threshold), block.Headers["Label"]
+	copy(s.share[:], block.Bytes)
+	return nil
+}
+
+// threshold returns the number of shares needed to recover the file key, or
+// 0 if it isn't shared.
+func (f *EncFile) threshold() int {
+	for _, keyBox := range f.keyBoxes {
+		if keyBox.threshold > 0 {
+			return keyBox.threshold
+		}
+	}
+	return 0
+}
+
+// Threshold returns the number of key shares needed to recover the file key,
+// or 0 if it has no threshold recipients.
+func (f *EncFile) Threshold() int {
+	return f.threshold()
+}
+
+// OpenShares opens the share key boxes of any of the given Identities.
+func (f *EncFile) OpenShares(identities ...Identity) ([]*KeyShare, error) {
+	var shares []*KeyShare
+	for _, identity := range identities {
+		keyBox := f.getKeyBox(identity.Recipient())
+		if keyBox == nil || keyBox.threshold == 0 {
+			continue
+		}
+		share, err := identity.OpenKeyBox(keyBox)
+		if err != nil {
+			return shares, err
+		}
+		shares = append(shares, &KeyShare{
+			Index:     int(keyBox.shareIndex),
+			Threshold: keyBox.threshold,
+			Label:     keyBox.Label,
+			share:     *share,
+		})
+	}
+	if len(shares) == 0 {
+		return nil, ErrKeyBoxNotFound
+	}
+	return shares, nil
+}
+
+// UnsealShares recovers the file key from key shares and unseals the
+// EncFile with it.
+func (f *EncFile) UnsealShares(shares ...*KeyShare) (*UnsealedEncFile, error) {
+	threshold := f.threshold()
+	if threshold == 0 {
+		return nil, errors.New("file has no threshold recipients")
+	}
+
+	// Ignore repeated shares, e.g. both exported and opened locally
+	var xs []byte
+	var ys [][]byte
+	seen := map[int][32]byte{}
+	for _, share := range shares {
+		if other, ok := seen[share.Index]; ok {
+			if other != share.share {
+				return nil, errSharesMismatch
+			}
+			continue
+		}
+		seen[share.Index] = share.share
+		xs = append(xs, byte(share.Index))
+		ys = append(ys, share.share[:])
+	}
+	if len(xs) < threshold {
+		return nil, fmt.Errorf("%w: have %d of %d", errNotEnoughShares, len(xs), threshold)
+	}
+
+	secret, err := combineShares(xs, ys)
+	if err != nil {
+		return nil, err
+	}
+	var fileKey [32]byte
+	copy(fileKey[:], secret)
+
+	unsealed, err := f.unsealWith(&fileKey)
+	if errors.Is(err, errHeaderMACMismatch) || (err == nil && len(f.headerMAC) == 0) {
+		return nil, errSharesMismatch
+	}
+	return unsealed, err
+}
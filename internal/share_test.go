@@ -0,0 +1,180 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func generateThresholdEncFile(t *testing.T, n, threshold int) (*EncFile, []*PrivateKey) {
+	unsealedFile, _, _ := generateTestUnsealedEncFile(t)
+
+	var pubKeys []*PublicKey
+	var privKeys []*PrivateKey
+	for i := 0; i < n; i++ {
+		pubKey, privKey, err := GenerateKeys(string(rune('a' + i)))
+		assert.NoError(t, err)
+		pubKeys = append(pubKeys, pubKey)
+		privKeys = append(privKeys, privKey)
+	}
+	err := unsealedFile.AddThresholdPublicKeys(threshold, pubKeys...)
+	assert.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	_, err = unsealedFile.WriteTo(buf)
+	assert.NoError(t, err)
+	encFile := &EncFile{}
+	_, err = encFile.ReadFrom(buf)
+	assert.NoError(t, err)
+	return encFile, privKeys
+}
+
+func TestEncFile_Threshold(t *testing.T) {
+	encFile, privKeys := generateThresholdEncFile(t, 3, 2)
+	assert.Equal(t, 2, encFile.Threshold())
+	assert.Len(t, encFile.PublicKeys(), 4)
+
+	// One share holder alone can't unseal
+	_, err := encFile.Unseal(privKeys[0])
+	var noKeyBoxErr *NoKeyBoxError
+	if assert.True(t, errors.As(err, &noKeyBoxErr)) {
+		assert.Equal(t, 1, noKeyBoxErr.Shares)
+		assert.Equal(t, 2, noKeyBoxErr.Threshold)
+	}
+
+	// Two can, together
+	unsealedFile, err := encFile.Unseal(privKeys[2], privKeys[0])
+	if assert.NoError(t, err) {
+		plaintext, err := unsealedFile.Decrypt()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("testData"), plaintext)
+	}
+}
+
+func TestEncFile_UnsealShares(t *testing.T) {
+	encFile, privKeys := generateThresholdEncFile(t, 3, 2)
+
+	var shares []*KeyShare
+	for _, privKey := range privKeys {
+		opened, err := encFile.OpenShares(privKey)
+		assert.NoError(t, err)
+		if !assert.Len(t, opened, 1) {
+			return
+		}
+
+		// Shares survive export
+		data, err := opened[0].Marshal()
+		assert.NoError(t, err)
+		share := &KeyShare{}
+		assert.NoError(t, share.Unmarshal(data))
+		assert.Equal(t, opened[0], share)
+		shares = append(shares, share)
+	}
+
+	_, err := encFile.UnsealShares(shares[1], shares[1])
+	assert.True(t, errors.Is(err, errNotEnoughShares))
+
+	unsealedFile, err := encFile.UnsealShares(shares[1], shares[2])
+	if assert.NoError(t, err) {
+		plaintext, err := unsealedFile.Decrypt()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("testData"), plaintext)
+	}
+
+	// Shares of another file don't work
+	otherFile, otherPrivKeys := generateThresholdEncFile(t, 2, 2)
+	otherShares, err := otherFile.OpenShares(otherPrivKeys[0], otherPrivKeys[1])
+	assert.NoError(t, err)
+	_, err = encFile.UnsealShares(otherShares...)
+	assert.True(t, errors.Is(err, errSharesMismatch))
+
+	_, err = encFile.OpenShares(otherPrivKeys[0])
+	assert.True(t, errors.Is(err, ErrKeyBoxNotFound))
+}
+
+func TestUnsealedEncFile_RotateFileKeyShares(t *testing.T) {
+	encFile, privKeys := generateThresholdEncFile(t, 3, 2)
+	oldShares, err := encFile.OpenShares(privKeys[0], privKeys[1], privKeys[2])
+	assert.NoError(t, err)
+
+	unsealedFile, err := encFile.Unseal(privKeys[0], privKeys[1])
+	assert.NoError(t, err)
+	assert.NoError(t, unsealedFile.RotateFileKey())
+	assert.Equal(t, 2, unsealedFile.Threshold())
+
+	err = unsealedFile.AddThresholdPublicKeys(2, unsealedFile.PublicKeys()[0])
+	assert.True(t, errors.Is(err, errThresholdExists))
+
+	buf := &bytes.Buffer{}
+	_, err = unsealedFile.WriteTo(buf)
+	assert.NoError(t, err)
+	encFile = &EncFile{}
+	_, err = encFile.ReadFrom(buf)
+	assert.NoError(t, err)
+
+	_, err = encFile.UnsealShares(oldShares...)
+	assert.True(t, errors.Is(err, errSharesMismatch))
+
+	_, err = encFile.Unseal(privKeys[1], privKeys[2])
+	assert.NoError(t, err)
+}
+
+func TestKeyShare_UnmarshalInvalid(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"-----BEGIN DEVCRYPT KEY SHARE-----\nIndex: 1\nThreshold: 1\n\nAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=\n-----END DEVCRYPT KEY SHARE-----\n",
+		"-----BEGIN DEVCRYPT KEY SHARE-----\nIndex: 0\nThreshold: 2\n\nAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=\n-----END DEVCRYPT KEY SHARE-----\n",
+		"-----BEGIN DEVCRYPT KEY SHARE-----\nIndex: 1\nThreshold: 2\n\nAAAA\n-----END DEVCRYPT KEY SHARE-----\n",
+	} {
+		err := (&KeyShare{}).Unmarshal([]byte(data))
+		assert.Equal(t, errBadShare, err, "%q", data)
+	}
+}
+
+func TestUnsealedEncFile_RemoveShareHolder(t *testing.T) {
+	encFile, privKeys := generateThresholdEncFile(t, 3, 2)
+	unsealedFile, err := encFile.Unseal(privKeys[0], privKeys[1])
+	assert.NoError(t, err)
+
+	// As prune-expired does: remove a share holder, then rotate
+	assert.NoError(t, unsealedFile.RemovePublicKey(unsealedFile.getKeyBox(privKeys[2].Recipient()).PublicKey))
+	assert.NoError(t, unsealedFile.RotateFileKey())
+
+	buf := &bytes.Buffer{}
+	_, err = unsealedFile.WriteTo(buf)
+	assert.NoError(t, err)
+	encFile = &EncFile{}
+	_, err = encFile.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, encFile.Threshold())
+	unsealedFile, err = encFile.Unseal(privKeys[0], privKeys[1])
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// The last two shares are both needed
+	keyBoxes := len(unsealedFile.KeyBoxes())
+	err = unsealedFile.RemovePublicKey(unsealedFile.getKeyBox(privKeys[1].Recipient()).PublicKey)
+	assert.True(t, errors.Is(err, ErrTooFewShares))
+	assert.Len(t, unsealedFile.KeyBoxes(), keyBoxes)
+	assert.NoError(t, unsealedFile.RotateFileKey())
+}
+
+func TestUnsealedEncFile_PruneExpiredShareHolder(t *testing.T) {
+	encFile, privKeys := generateThresholdEncFile(t, 3, 3)
+	unsealedFile, err := encFile.Unseal(privKeys[0], privKeys[1], privKeys[2])
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// An expired share holder of a 3-of-3 set can't be pruned
+	expired := unsealedFile.getKeyBox(privKeys[0].Recipient()).PublicKey
+	expired.Expires = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	err = unsealedFile.RemovePublicKey(expired)
+	assert.True(t, errors.Is(err, ErrTooFewShares))
+	assert.NoError(t, unsealedFile.RotateFileKey())
+	assert.Equal(t, 3, unsealedFile.Threshold())
+}